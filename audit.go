@@ -0,0 +1,337 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    audit.go
+ *  Description: JSON-lines audit logs of task lifecycle events.
+ */
+
+package dispatch
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sync"
+    "time"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  The number of records an AuditLog holds for its writer goroutine before
+//  the tasks emitting events must wait.
+var AuditBuffer = 1024
+
+//  The most bytes of records an AuditLog collects before writing them.
+const auditBatch = 64 << 10
+
+//  The error returned when writing to a closed AuditLog.
+var ErrAuditClosed = errors.New("dispatch: audit log closed")
+
+//  An AuditLog appends a JSON object for each lifecycle event of the tasks
+//  of the Dispatches attached to it, one object per line. Records are
+//  handed to a goroutine which collects them and writes them whenever it
+//  runs out of records, so a slow writer doesn't hold up the dispatcher
+//  until AuditBuffer records are waiting. Each write holds whole lines.
+//      var f, _ = dispatch.NewRotatingFile("audit.log", 64<<20, 4)
+//      var al = dispatch.NewAuditLog(f)
+//      al.Attach(gq)
+//      ...
+//      al.Close()
+//      f.Close()
+type AuditLog struct {
+    records   chan auditRecord
+    quit      chan bool // Closed by Close().
+    closeOnce *sync.Once
+    done      chan bool // Closed when the writer goroutine exits.
+    errLock   *sync.Mutex
+    err       error // The first write error.
+}
+
+//  One line of an audit log.
+type auditRecord struct {
+    Dispatch string     `json:"dispatch"`
+    Event    string     `json:"event"`
+    Id       int64      `json:"id"`
+    Type     string     `json:"type"`
+    Key      *float64   `json:"key,omitempty"`
+    Attempt  int        `json:"attempt"`
+    Enqueued time.Time  `json:"enqueued"`
+    Queued   time.Time  `json:"queued"`
+    Started  *time.Time `json:"started,omitempty"`
+    Finished *time.Time `json:"finished,omitempty"`
+    Error    string     `json:"error,omitempty"`
+
+    flushed chan error // Non-nil for flush requests, which aren't written.
+}
+
+//  Create an AuditLog writing to w. The AuditLog doesn't close w.
+func NewAuditLog(w io.Writer) *AuditLog {
+    var al = new(AuditLog)
+    al.records = make(chan auditRecord, AuditBuffer)
+    al.quit = make(chan bool)
+    al.closeOnce = new(sync.Once)
+    al.done = make(chan bool)
+    al.errLock = new(sync.Mutex)
+    go al.write(w)
+    return al
+}
+
+//  Start recording the lifecycle events of gq's tasks.
+func (al *AuditLog) Attach(gq *Dispatch) {
+    var name = gq.Name
+    var record = func(e TaskEvent) { al.put(name, e) }
+    gq.AddHooks(Hooks{
+        OnEnqueue: record,
+        OnStart:   record,
+        OnFinish:  record,
+        OnDrop:    record,
+    })
+}
+
+//  Queue a record of event e for the writer goroutine.
+func (al *AuditLog) put(name string, e TaskEvent) {
+    var rec = auditRecord{
+        Dispatch: name,
+        Event:    e.Kind.String(),
+        Id:       e.Task.Id(),
+        Type:     e.Task.Task().Type(),
+        Attempt:  e.Attempt,
+        Enqueued: e.Enqueued,
+        Queued:   e.Queued,
+    }
+    if pt, ok := e.Task.Task().(queues.PrioritizedTask); ok {
+        var key = pt.Key()
+        rec.Key = &key
+    }
+    if !e.Started.IsZero() {
+        var started = e.Started
+        rec.Started = &started
+    }
+    if !e.Finished.IsZero() {
+        var finished = e.Finished
+        rec.Finished = &finished
+    }
+    if e.Err != nil {
+        rec.Error = e.Err.Error()
+    }
+    select {
+    case al.records <- rec:
+    case <-al.quit:
+    }
+}
+
+//  Write records to w until al.quit is closed and the records waiting
+//  have been written.
+func (al *AuditLog) write(w io.Writer) {
+    defer close(al.done)
+    var (
+        batch []byte
+        err   error
+    )
+    var flush = func() {
+        if err == nil && len(batch) > 0 {
+            _, err = w.Write(batch)
+        }
+        batch = batch[:0]
+    }
+    var handle = func(rec auditRecord) {
+        if rec.flushed != nil {
+            flush()
+            rec.flushed <- err
+            return
+        }
+        if err != nil {
+            return
+        }
+        var line []byte
+        if line, err = json.Marshal(rec); err != nil {
+            return
+        }
+        batch = append(append(batch, line...), '\n')
+        if len(al.records) == 0 || len(batch) >= auditBatch {
+            flush()
+        }
+    }
+    for quit := false ; !quit ; {
+        select {
+        case rec := <-al.records:
+            handle(rec)
+        case <-al.quit:
+            for len(al.records) > 0 {
+                handle(<-al.records)
+            }
+            quit = true
+        }
+        if err != nil {
+            // Keep draining, so put() never waits on a broken writer.
+            al.setErr(err)
+        }
+    }
+    flush()
+    al.setErr(err)
+}
+
+//  Remember the first write error.
+func (al *AuditLog) setErr(err error) {
+    al.errLock.Lock()
+    defer al.errLock.Unlock()
+    if al.err == nil {
+        al.err = err
+    }
+}
+
+//  Wait for every event recorded so far to be written. Returns the first
+//  error encountered writing the log, after which nothing more is written.
+func (al *AuditLog) Flush() error {
+    var flushed = make(chan error, 1)
+    select {
+    case al.records <- auditRecord{flushed: flushed}:
+    case <-al.quit:
+        return ErrAuditClosed
+    }
+    select {
+    case err := <-flushed:
+        return err
+    case <-al.done:
+        select {
+        case err := <-flushed:
+            return err
+        default:
+        }
+        // Closed before the request was handled.
+        return ErrAuditClosed
+    }
+}
+
+//  Stop recording, and wait for recorded events to be written. Events
+//  emitted by attached Dispatches after Close are discarded.
+func (al *AuditLog) Close() error {
+    var closed = false
+    al.closeOnce.Do(func() {
+        close(al.quit)
+        closed = true
+    })
+    if !closed {
+        return ErrAuditClosed
+    }
+    <-al.done
+    al.errLock.Lock()
+    defer al.errLock.Unlock()
+    return al.err
+}
+
+//  A RotatingFile is an io.Writer appending to a file, which it renames
+//  when a write would take it past a maximum size. The previous files are
+//  kept as path.1 (the most recent) through path.N, and older ones are
+//  removed. Each Write goes wholly into one file, so a line written in one
+//  call is never split between files. It is safe to use from multiple
+//  goroutines.
+type RotatingFile struct {
+    lock    *sync.Mutex
+    path    string
+    maxSize int64
+    keep    int
+    file    *os.File
+    size    int64
+    closed  bool
+}
+
+//  Open the file at path for appending, creating it if it doesn't exist.
+//  The file is rotated before it would grow past maxSize bytes, keeping
+//  at most keep previous files.
+func NewRotatingFile(path string, maxSize int64, keep int) (*RotatingFile, error) {
+    if maxSize <= 0 {
+        return nil, fmt.Errorf("dispatch: invalid maximum file size %d", maxSize)
+    }
+    var rf = new(RotatingFile)
+    rf.lock = new(sync.Mutex)
+    rf.path = path
+    rf.maxSize = maxSize
+    rf.keep = keep
+    if err := rf.open(); err != nil {
+        return nil, err
+    }
+    return rf, nil
+}
+
+//  Open rf.path and find its size.
+func (rf *RotatingFile) open() error {
+    var file, err = os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+    if err != nil {
+        return err
+    }
+    var info os.FileInfo
+    if info, err = file.Stat(); err != nil {
+        file.Close()
+        return err
+    }
+    rf.file = file
+    rf.size = info.Size()
+    return nil
+}
+
+//  Rename the current file to path.1, shifting older files up, and start
+//  a new one.
+func (rf *RotatingFile) rotate() error {
+    if err := rf.file.Close(); err != nil {
+        return err
+    }
+    rf.file = nil
+    var err error
+    if rf.keep <= 0 {
+        err = os.Remove(rf.path)
+    } else {
+        for i := rf.keep - 1; i > 0; i-- {
+            var old = fmt.Sprintf("%s.%d", rf.path, i)
+            if err = os.Rename(old, fmt.Sprintf("%s.%d", rf.path, i+1)); err != nil && !os.IsNotExist(err) {
+                return err
+            }
+        }
+        err = os.Rename(rf.path, rf.path+".1")
+    }
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return rf.open()
+}
+
+//  Append p to the file, rotating it first if it would grow too large.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+    rf.lock.Lock()
+    defer rf.lock.Unlock()
+    if rf.closed {
+        return 0, os.ErrClosed
+    }
+    if rf.file == nil {
+        // A previous rotation failed part way; try to recover.
+        if err := rf.open(); err != nil {
+            return 0, err
+        }
+    }
+    if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+        if err := rf.rotate(); err != nil {
+            return 0, err
+        }
+    }
+    var n, err = rf.file.Write(p)
+    rf.size += int64(n)
+    return n, err
+}
+
+//  Close the current file.
+func (rf *RotatingFile) Close() error {
+    rf.lock.Lock()
+    defer rf.lock.Unlock()
+    if rf.closed {
+        return os.ErrClosed
+    }
+    rf.closed = true
+    if rf.file == nil {
+        return nil
+    }
+    var err = rf.file.Close()
+    rf.file = nil
+    return err
+}
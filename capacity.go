@@ -0,0 +1,192 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    capacity.go
+ *  Description: Bounded queue capacity, load shedding and watermarks.
+ */
+
+package dispatch
+
+import (
+    "context"
+    "errors"
+    "time"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  The error returned when a task is refused because the queue is full.
+var ErrFull = errors.New("dispatch: queue full")
+
+//  The error of a Future whose task was dropped from a full queue to make
+//  room for another.
+var ErrShed = errors.New("dispatch: task shed from full queue")
+
+//  A FullPolicy decides what happens to a task enqueued when a Dispatch's
+//  queue has reached its Capacity.
+type FullPolicy int
+
+const (
+    // Wait for space in the queue. EnqueueContext gives up when its
+    // context is done. This is the default.
+    FullBlock FullPolicy = iota
+    // Refuse the task with ErrFull.
+    FullReject
+    // Drop the task which was enqueued first to make room.
+    FullDropOldest
+    // Drop the queued task with the highest key (the lowest priority) to
+    // make room. If the new task's key is at least as high, or the tasks
+    // aren't queues.PrioritizedTasks, the new task is refused instead.
+    FullEvictLowest
+    // Drop every queued task whose deadline has passed (see DeadlineTask)
+    // or whose context is done. If that doesn't make room, the new task
+    // is refused.
+    FullDropExpired
+)
+
+//  A task dropped from the queue by a FullPolicy, and why.
+type shedTask struct {
+    w   *dispatchTaskWrapper
+    err error
+}
+
+//  Enqueue a task without waiting for space in the queue. Returns ErrFull
+//  when the queue is at capacity and gq.FullPolicy is FullBlock, as well
+//  as when the policy refuses the task. See gq.Enqueue(t).
+func (gq *Dispatch) TryEnqueue(t queues.Task) (int64, error) {
+    var w, err = gq.enqueue(context.Background(), t, false)
+    if err != nil {
+        return 0, err
+    }
+    return w.id, nil
+}
+
+//  Wait until task t may be put in the queue, shedding tasks if that is
+//  gq.FullPolicy. If the queue stays full and the policy doesn't block, or
+//  wait is false, ErrFull is returned. The caller must hold gq.qLock,
+//  which is released while waiting. Tasks removed from the queue to make
+//  room are returned, even with an error, and the caller must drop them
+//  once gq.qLock is released.
+func (gq *Dispatch) admit(ctx context.Context, t queues.Task, wait bool) ([]shedTask, error) {
+    for {
+        if gq.closed {
+            return nil, ErrClosed
+        }
+        if gq.Capacity <= 0 || gq.queue.Len() < gq.Capacity {
+            return nil, nil
+        }
+        if gq.FullPolicy != FullBlock {
+            var shed = gq.shed(t)
+            if gq.queue.Len() < gq.Capacity {
+                return shed, nil
+            }
+            return shed, ErrFull
+        }
+        if !wait {
+            return nil, ErrFull
+        }
+        if gq.space == nil {
+            gq.space = make(chan bool)
+        }
+        var space = gq.space
+        gq.qLock.Unlock()
+        select {
+        case <-space:
+        case <-ctx.Done():
+            gq.qLock.Lock()
+            return nil, ctx.Err()
+        }
+        gq.qLock.Lock()
+    }
+}
+
+//  Remove tasks from the full queue according to gq.FullPolicy, to make
+//  room for task t. The caller must hold gq.qLock.
+func (gq *Dispatch) shed(t queues.Task) []shedTask {
+    var victim *dispatchTaskWrapper
+    switch gq.FullPolicy {
+    case FullDropOldest:
+        gq.queue.Do(func(task queues.RegisteredTask) bool {
+            var w = task.(*dispatchTaskWrapper)
+            if victim == nil || w.enqueued.Before(victim.enqueued) {
+                victim = w
+            }
+            return true
+        })
+    case FullEvictLowest:
+        var pt, ok = t.(queues.PrioritizedTask)
+        if !ok {
+            return nil
+        }
+        var key = pt.Key()
+        gq.queue.Do(func(task queues.RegisteredTask) bool {
+            var qt, ok = task.Task().(queues.PrioritizedTask)
+            if !ok {
+                victim = nil
+                return false
+            }
+            if qt.Key() > key {
+                victim, key = task.(*dispatchTaskWrapper), qt.Key()
+            }
+            return true
+        })
+    case FullDropExpired:
+        var (
+            now  = time.Now()
+            shed []shedTask
+        )
+        gq.queue.RemoveIf(func(task queues.RegisteredTask) bool {
+            var w = task.(*dispatchTaskWrapper)
+            if err := w.ctx.Err(); err != nil {
+                shed = append(shed, shedTask{w, err})
+                return true
+            }
+            if dt, ok := w.t.(DeadlineTask); ok {
+                var d = dt.Deadline()
+                if !d.IsZero() && !d.After(now) {
+                    shed = append(shed, shedTask{w, ErrTimeout})
+                    return true
+                }
+            }
+            return false
+        })
+        return shed
+    }
+    if victim == nil {
+        return nil
+    }
+    gq.queue.Remove(victim.id)
+    return []shedTask{{victim, ErrShed}}
+}
+
+//  Called after the queue length changes, to wake producers waiting for
+//  space and check the watermarks. Returns a function which calls any
+//  watermark callback, for the caller to call after releasing gq.qLock.
+//  The caller must hold gq.qLock.
+func (gq *Dispatch) resized() func() {
+    var n = gq.queue.Len()
+    if gq.space != nil && (gq.closed || gq.Capacity <= 0 || n < gq.Capacity) {
+        close(gq.space)
+        gq.space = nil
+    }
+    var callback func(int)
+    switch {
+    case !gq.high && gq.HighWater > 0 && n >= gq.HighWater:
+        gq.high = true
+        callback = gq.OnHighWater
+    case gq.high && n <= gq.LowWater:
+        gq.high = false
+        callback = gq.OnLowWater
+    }
+    if callback == nil {
+        return func() {}
+    }
+    return func() { callback(n) }
+}
+
+//  Drop the tasks removed from the queue by gq.shed(t).
+func (gq *Dispatch) dropShed(shed []shedTask) {
+    for _, s := range shed {
+        gq.drop(s.w, s.err)
+    }
+}
@@ -0,0 +1,219 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    deadletter.go
+ *  Description: Dead-letter sinks for tasks which fail permanently.
+ */
+
+package dispatch
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  A DeadLetter records a task which failed and will not be retried.
+type DeadLetter struct {
+    Task     queues.RegisteredTask
+    Id       int64
+    Err      error       // The error of the final attempt.
+    Panic    interface{} // The panic value, if the final attempt panicked.
+    Attempts int
+    Enqueued time.Time
+    Started  time.Time // When the final attempt started.
+    Failed   time.Time
+}
+
+//  A DeadLetterSink receives the tasks of a Dispatch which fail
+//  permanently. Put is called from task goroutines, so it must be safe
+//  to call concurrently.
+type DeadLetterSink interface {
+    Put(DeadLetter) error
+}
+
+//  Send the failed task w to gq.DeadLetters, if it is set.
+func (gq *Dispatch) deadLetter(w *dispatchTaskWrapper, err error) {
+    if gq.DeadLetters == nil {
+        return
+    }
+    var dl = DeadLetter{
+        Task:     w,
+        Id:       w.id,
+        Err:      err,
+        Attempts: w.attempt,
+        Enqueued: w.enqueued,
+        Started:  w.started,
+        Failed:   time.Now(),
+    }
+    var pe *PanicError
+    if errors.As(err, &pe) {
+        dl.Panic = pe.Value
+    }
+    // The sink may redrive the task, so it must have its own function back
+    // first. The sink is responsible for reporting its own failures.
+    gq.restore(w)
+    gq.DeadLetters.Put(dl)
+}
+
+//  Enqueue the tasks of dead letters again, once whatever made them fail
+//  has been fixed. The tasks are given new ids, which are returned. An
+//  error is returned if the Dispatch stops accepting tasks part way.
+//      ids, err := gq.Redrive(deadletters.Take()...)
+func (gq *Dispatch) Redrive(letters ...DeadLetter) ([]int64, error) {
+    var ids = make([]int64, 0, len(letters))
+    for _, dl := range letters {
+        var id, err = gq.EnqueueContext(context.Background(), dl.Task.Task())
+        if err != nil {
+            return ids, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, nil
+}
+
+//  A DeadLetterSink which holds dead letters in memory.
+type MemoryDeadLetters struct {
+    lock    *sync.Mutex
+    letters []DeadLetter
+    limit   int // The most letters held, oldest dropped first. Zero is unbounded.
+}
+
+//  Create a new, empty, MemoryDeadLetters holding at most limit letters.
+//  Once it is full the oldest letter is dropped for each new one. If limit
+//  is not positive the number of letters held is unbounded.
+func NewMemoryDeadLetters(limit int) *MemoryDeadLetters {
+    var m = new(MemoryDeadLetters)
+    m.lock = new(sync.Mutex)
+    if limit > 0 {
+        m.limit = limit
+    }
+    return m
+}
+
+//  Store a dead letter for the DeadLetterSink interface.
+func (m *MemoryDeadLetters) Put(dl DeadLetter) error {
+    m.lock.Lock()
+    m.letters = append(m.letters, dl)
+    if m.limit > 0 && len(m.letters) > m.limit {
+        var n = copy(m.letters, m.letters[len(m.letters)-m.limit:])
+        for i := n ; i < len(m.letters) ; i++ {
+            m.letters[i] = DeadLetter{}
+        }
+        m.letters = m.letters[:n]
+    }
+    m.lock.Unlock()
+    return nil
+}
+
+//  The number of dead letters held.
+func (m *MemoryDeadLetters) Len() int {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    return len(m.letters)
+}
+
+//  Returns a copy of the dead letters held, in the order they were put.
+func (m *MemoryDeadLetters) Letters() []DeadLetter {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    var letters = make([]DeadLetter, len(m.letters))
+    copy(letters, m.letters)
+    return letters
+}
+
+//  Remove and return all of the dead letters held. See Dispatch.Redrive.
+func (m *MemoryDeadLetters) Take() []DeadLetter {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    var letters = m.letters
+    m.letters = nil
+    return letters
+}
+
+//  The JSON representation of a DeadLetter, one per line of a file.
+type deadLetterRecord struct {
+    Id       int64     `json:"id"`
+    Type     string    `json:"type"`
+    Key      *float64  `json:"key,omitempty"`
+    Error    string    `json:"error"`
+    Panic    string    `json:"panic,omitempty"`
+    Attempts int       `json:"attempts"`
+    Enqueued time.Time `json:"enqueued"`
+    Started  time.Time `json:"started"`
+    Failed   time.Time `json:"failed"`
+}
+
+//  A DeadLetterSink which appends a JSON object to a file for each dead
+//  letter. Task functions can't be written to the file, so the most recent
+//  letters may also be held in memory (as with MemoryDeadLetters) where
+//  they can be taken and redriven by the process which wrote them.
+type FileDeadLetters struct {
+    *MemoryDeadLetters
+    keep  int
+    flock *sync.Mutex
+    file  *os.File
+    enc   *json.Encoder
+}
+
+//  Open the file at path for appending dead letters, creating it if it
+//  doesn't exist. The last keep letters are also held in memory for
+//  Redrive; older ones are only in the file. If keep is not positive,
+//  nothing is held in memory.
+func NewFileDeadLetters(path string, keep int) (*FileDeadLetters, error) {
+    var file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+    if err != nil {
+        return nil, err
+    }
+    var fdl = new(FileDeadLetters)
+    fdl.MemoryDeadLetters = NewMemoryDeadLetters(keep)
+    fdl.keep = keep
+    fdl.flock = new(sync.Mutex)
+    fdl.file = file
+    fdl.enc = json.NewEncoder(file)
+    return fdl, nil
+}
+
+//  Write a dead letter to the file, and hold it in memory if letters are
+//  kept.
+func (fdl *FileDeadLetters) Put(dl DeadLetter) error {
+    if fdl.keep > 0 {
+        fdl.MemoryDeadLetters.Put(dl)
+    }
+    var rec = deadLetterRecord{
+        Id:       dl.Id,
+        Attempts: dl.Attempts,
+        Enqueued: dl.Enqueued,
+        Started:  dl.Started,
+        Failed:   dl.Failed,
+    }
+    if dl.Task != nil {
+        rec.Type = dl.Task.Task().Type()
+        if pt, ok := dl.Task.Task().(queues.PrioritizedTask); ok {
+            var key = pt.Key()
+            rec.Key = &key
+        }
+    }
+    if dl.Err != nil {
+        rec.Error = dl.Err.Error()
+    }
+    if dl.Panic != nil {
+        rec.Panic = fmt.Sprint(dl.Panic)
+    }
+    fdl.flock.Lock()
+    defer fdl.flock.Unlock()
+    return fdl.enc.Encode(rec)
+}
+
+//  Close the underlying file. Letters held in memory remain available.
+func (fdl *FileDeadLetters) Close() error {
+    fdl.flock.Lock()
+    defer fdl.flock.Unlock()
+    return fdl.file.Close()
+}
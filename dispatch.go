@@ -22,38 +22,132 @@ package dispatch
 
 import (
     "sync"
-    //"log"
+    "context"
+    "errors"
+    "log/slog"
+    "time"
     "github.com/bmatsuo/dispatch/queues"
 )
 
 //  A Dispatch is an automated function dispatch queue with a limited
 //  number of concurrent gorountines. The queue can be altered with the
-//  Dispatch methods Enqueue and SetKey (TODO add method Remove).
+//  Dispatch methods Enqueue, SetKey, Remove and RemoveIf.
 type Dispatch struct {
-    // The maximum number of goroutines can be changed while the queue is
-    // processing.
+    // Identifies the Dispatch in metrics and logs.
+    Name string
+    published string // The expvar name, protected by expvarLock.
+
+    // The maximum number of goroutines.
+    //
+    // Deprecated: Writes to MaxGo are only noticed when the Dispatch is
+    // started, and they race with a running Dispatch. Use SetMaxGo() and
+    // CurrentMaxGo() instead.
     MaxGo int
 
+    // Whether tasks may start ahead of a WeightedTask at the head of the
+    // queue which is too heavy to start yet. Should be set before the
+    // Dispatch is started.
+    WeightPolicy WeightPolicy
+
+    // Adjusts the concurrency limit as tasks finish, if non-nil. The limit
+    // given to New or SetMaxGo is where the Limiter starts. It should be
+    // set before the Dispatch is started.
+    Limiter Limiter
+
+    // How to retry tasks which fail. Tasks implementing RetryTask use their
+    // own policy instead. A nil policy means failed tasks are not retried.
+    Retry *RetryPolicy
+
+    // Receives tasks which fail and will not be retried. May be nil.
+    DeadLetters DeadLetterSink
+
+    // Where to log task lifecycles and dispatch state transitions, if it's
+    // non-nil. LogLevels overrides DefaultLogLevels. Both should be set
+    // before the Dispatch is started.
+    Logger    *slog.Logger
+    LogLevels *LogLevels
+
+    // What to do when a task panics. Recovered panics are passed to
+    // PanicHandler, if it is non-nil, as well as the task's Future. Both
+    // should be set before the Dispatch is started.
+    PanicPolicy  PanicPolicy
+    PanicHandler func(*PanicError)
+
+    // The maximum length of the queue, if positive. FullPolicy decides what
+    // happens to tasks enqueued when the queue is full. Tasks waiting to be
+    // retried are put back in the queue regardless of its length.
+    Capacity   int
+    FullPolicy FullPolicy
+
+    // OnHighWater is called when the queue length reaches HighWater, if it
+    // is positive. OnLowWater is then called when the length falls back to
+    // LowWater. The callbacks are given the queue length, and are called
+    // without any locks held by the goroutine that changed the length. All
+    // of these fields should be set before the Dispatch is used.
+    HighWater   int
+    LowWater    int
+    OnHighWater func(length int)
+    OnLowWater  func(length int)
+
     // Handle waiting when the limit of concurrent goroutines has been reached.
     waitingToRun bool
     nextWait     *sync.WaitGroup
 
-    // Handle waiting when function queue is empty.
+    // Handle waiting when function queue is empty or paused.
     waitingOnQ bool
     restart    *sync.WaitGroup
+    paused     bool // Protected by qLock.
 
     // Manage the Start()'ing of a Dispatch, avoiding race conditions.
     startLock *sync.Mutex
     started   bool
 
     // Handle goroutine-safe queue operations.
-    qLock *sync.Mutex
-    queue queues.Queue
+    qLock   *sync.Mutex
+    queue   queues.Queue
+    closed  bool // Set by Shutdown(), new tasks are refused.
+    aborted bool // Set when Shutdown() discards the queue.
+    space   chan bool // Closed when the queue has room, if anyone waits.
+    high    bool      // The queue reached HighWater and not yet LowWater.
 
     // Handle goroutine-safe limiting and identifier operations.
     pLock      *sync.Mutex
-    processing int   // Number of QueueTasks running
+    processing int   // Weight of the QueueTasks running
+    maxgo      int   // The limit on processing
+    fieldMaxGo int   // The value of MaxGo when maxgo was last synced
     idcount    int64 // pid counter
+    running    map[int64]*dispatchTaskWrapper
+    slots      []bool // Slot indices in use by running tasks.
+    stuck      int // Tasks past their deadline that haven't returned.
+    report     *ShutdownReport // Non-nil during Shutdown().
+    bucket     tokenBucket     // The start rate limit, see SetRate().
+    heavy      bool            // next() waits for room for a WeightedTask.
+
+    // Count tasks which are queued, running or waiting to be retried.
+    inflight *sync.WaitGroup
+
+    // Tasks waiting to be retried, protected by qLock.
+    retrying map[int64]*retryTimer
+
+    // Statistics for Stats(). The idle timer and enqueued counter are
+    // protected by qLock, the others by pLock.
+    idle      waitTimer
+    blocked   waitTimer
+    throttled waitTimer
+    enqueued  int64
+    begun     int64
+    completed int64
+    failed    int64
+    panicked  int64
+    timedOut  int64
+
+    // Latency histograms by task type.
+    latLock *sync.Mutex
+    latency map[string]*TaskLatency
+
+    // Lifecycle hooks, replaced (not modified) by AddHooks().
+    hLock *sync.Mutex
+    hooks []Hooks
 
     // The longest the dispatch queue grew.
     maxlength int
@@ -80,10 +174,17 @@ func NewCustom(maxroutines int, queue queues.Queue) *Dispatch {
     d.qLock = new(sync.Mutex)
     d.pLock = new(sync.Mutex)
     d.restart = new(sync.WaitGroup)
-    d.kill = make(chan bool)
     d.nextWait = new(sync.WaitGroup)
+    d.inflight = new(sync.WaitGroup)
+    d.running = make(map[int64]*dispatchTaskWrapper)
+    d.retrying = make(map[int64]*retryTimer)
+    d.latLock = new(sync.Mutex)
+    d.latency = make(map[string]*TaskLatency)
+    d.hLock = new(sync.Mutex)
     d.queue = queue
     d.MaxGo = maxroutines
+    d.maxgo = maxroutines
+    d.fieldMaxGo = maxroutines
     d.idcount = 0
     d.maxlength = 0
     return d
@@ -116,114 +217,464 @@ func (dt *StdTask) Func() func(id int64) {
     return dt.F
 }
 
-//  A simple struct combining a Task with a unique dispatch id.
+//  A ContextTask is a queues.Task whose function also receives the
+//  context.Context given to Dispatch.EnqueueContext. The Dispatch calls
+//  ContextFunc() in place of Func() when running a ContextTask.
+type ContextTask interface {
+    queues.Task
+    ContextFunc() func(ctx context.Context, id int64)
+}
+
+//  A simple context-aware task for use in a priority-less queue.
+type CtxTask struct {
+    F func(ctx context.Context, id int64)
+    f func(id int64)
+}
+
+//  Return the pointer to a newly allocated CtxTask.
+func NewContextTask(f func(context.Context, int64)) *CtxTask {
+    t := new(CtxTask)
+    t.F = f
+    return t
+}
+
+//  Returns "CtxTask" for the queues.Task interface.
+func (ct *CtxTask) Type() string {
+    return "CtxTask"
+}
+
+//  Function modifier method for the queues.Task interface. It does not
+//  alter the function returned by ct.ContextFunc().
+func (ct *CtxTask) SetFunc(f func(id int64)) {
+    ct.f = f
+}
+
+//  Function accessor method for the queues.Task interface. Unless
+//  ct.SetFunc() has been called, the returned function calls ct.F with
+//  context.Background().
+func (ct *CtxTask) Func() func(id int64) {
+    if ct.f != nil {
+        return ct.f
+    }
+    var f = ct.F
+    return func(id int64) { f(context.Background(), id) }
+}
+
+//  Function accessor method for the ContextTask interface.
+func (ct *CtxTask) ContextFunc() func(ctx context.Context, id int64) {
+    return ct.F
+}
+
+//  A simple struct combining a Task with a unique dispatch id, the
+//  context it was enqueued with and the Future for its results.
 type dispatchTaskWrapper struct {
-    id  int64
-    t   queues.Task
+    id       int64
+    t        queues.Task
+    ctx      context.Context
+    future   *Future
+    f        func(id int64) // The task's function before it was wrapped.
+    attempt  int            // Number of times the task has been started.
+    enqueued time.Time
+    queued   time.Time // When the task was last put in the queue.
+    started  time.Time // When the latest attempt started.
+    slot     int       // The slot of the latest attempt.
+    weight   int       // The concurrency the latest attempt holds.
+    restored bool      // The task's function has been given back.
 }
 
 //  Accessor for the contained Task's function.
-func (dtw dispatchTaskWrapper) Func() func(id int64) {
+func (dtw *dispatchTaskWrapper) Func() func(id int64) {
     return dtw.t.Func()
 }
 
 //  Accessor for the Task's unique dispatch id.
-func (dtw dispatchTaskWrapper) Id() int64 {
+func (dtw *dispatchTaskWrapper) Id() int64 {
     return dtw.id
 }
 
 //  Accessor for the contained Task object itself.
-func (dtw dispatchTaskWrapper) Task() queues.Task {
+func (dtw *dispatchTaskWrapper) Task() queues.Task {
     return dtw.t
 }
 
+//  Set the maximum number of tasks run concurrently. This takes effect
+//  immediately and may be called while the Dispatch is running. Raising
+//  the limit starts waiting tasks right away. Lowering it doesn't stop
+//  running tasks, but no more are started until enough of them finish.
+func (gq *Dispatch) SetMaxGo(n int) {
+    if n < 0 {
+        n = 0
+    }
+    gq.pLock.Lock()
+    gq.maxgo = n
+    gq.MaxGo = n
+    gq.fieldMaxGo = n
+    if gq.waitingToRun && gq.processing < n {
+        gq.waitingToRun = false
+        gq.nextWait.Done()
+    }
+    gq.pLock.Unlock()
+}
+
+//  Returns the current maximum number of tasks run concurrently. Unlike
+//  reading the MaxGo field, this is safe while the Dispatch is running.
+func (gq *Dispatch) CurrentMaxGo() int {
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    return gq.maxgo
+}
+
 //  Returns the current length of the Dispatch object's queue.
 func (gq *Dispatch) Len() int {
+    gq.qLock.Lock()
+    defer gq.qLock.Unlock()
     return gq.queue.Len()
 }
 //  Returns the maximum length attained by the Dispatch object's queue.
 func (gq *Dispatch) MaxLen() int {
+    gq.qLock.Lock()
+    defer gq.qLock.Unlock()
     return gq.maxlength
 }
 
 //  Enqueue a task for execution as a goroutine. The given queues.Task is
 //  given a unique id (int64) and stored in the Dispatch gq's backend
-//  queues.Queue object.
+//  queues.Queue object. Zero is returned, and the task is not enqueued,
+//  if gq.Shutdown() has been called, or if the queue is full and
+//  gq.FullPolicy does not block.
 func (gq *Dispatch) Enqueue(t queues.Task) int64 {
+    var id, _ = gq.EnqueueContext(context.Background(), t)
+    return id
+}
+
+//  Like gq.Enqueue(t), but the task is associated with ctx. If ctx is done
+//  before the task is dequeued, the task is dropped without running. If t
+//  is a ContextTask, ctx is passed to its function. A deadline on ctx also
+//  limits the task's running time, see TimeoutTask. An error is returned,
+//  and nothing is enqueued, when ctx is already done, when gq.Shutdown()
+//  has been called, or when the queue is full (see FullPolicy). If the
+//  policy is FullBlock, ctx also limits how long to wait for space.
+func (gq *Dispatch) EnqueueContext(ctx context.Context, t queues.Task) (int64, error) {
+    var w, err = gq.enqueue(ctx, t, true)
+    if err != nil {
+        return 0, err
+    }
+    return w.id, nil
+}
+
+//  Wrap t, install the wrapper closure as its function and put it in the
+//  queue. Called by all of the Enqueue methods. If wait is false the call
+//  never blocks for space in the queue.
+func (gq *Dispatch) enqueue(ctx context.Context, t queues.Task, wait bool) (*dispatchTaskWrapper, error) {
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    var w = &dispatchTaskWrapper{t: t, ctx: ctx, future: newFuture(), f: t.Func()}
+
     // Wrap the function so it works with the goroutine limiting code.
-    var f = t.Func()
+    var run = taskRunner(t)
     var dtFunc = func(id int64) {
-        // Run the given function.
-        f(id)
+        gq.emit(EventStart, w, time.Time{}, nil)
 
-        // Decrement the process counter.
-        gq.pLock.Lock()
-        //log.Printf("processing: %d, waiting: %v", gq.processing, gq.waitingToRun)
-        gq.processing--
-        if gq.waitingToRun {
-            gq.waitingToRun = false
-            gq.nextWait.Done()
+        // Run the given function, recovering any panic.
+        var x = gq.execute(w)
+        var value, err = gq.traced(w, x.ctx, run)
+        x.stop()
+
+        // Decrement the process counter, unless the deadline already did.
+        if gq.end(x, false) {
+            gq.release(w)
+            gq.complete(w, value, err)
         }
-        gq.pLock.Unlock()
     }
-    t.SetFunc(dtFunc)
 
     // Lock the queue and enqueue a new task.
     gq.qLock.Lock()
+    var shed, err = gq.admit(ctx, t, wait)
+    if err != nil {
+        var notify = gq.resized()
+        gq.qLock.Unlock()
+        notify()
+        gq.dropShed(shed)
+        return nil, err
+    }
+    t.SetFunc(dtFunc)
+    gq.inflight.Add(1)
+    gq.enqueued++
     gq.idcount++
-    var id = gq.idcount
-    gq.queue.Enqueue(dispatchTaskWrapper{id, t})
+    w.id = gq.idcount
+    w.future.id = w.id
+    w.enqueued = time.Now()
+    gq.push(w)
+    var e = gq.event(EventEnqueue, w, time.Time{}, nil)
+    var notify = gq.resized()
+    gq.qLock.Unlock()
+
+    notify()
+    gq.dropShed(shed)
+    gq.fire(w, e)
+    return w, nil
+}
+
+//  Put w in the queue and wake gq.Start() if it is waiting on an empty
+//  queue. The caller must hold gq.qLock.
+func (gq *Dispatch) push(w *dispatchTaskWrapper) {
+    w.queued = time.Now()
+    gq.queue.Enqueue(w)
     if gq.waitingOnQ {
         gq.waitingOnQ = false
         gq.restart.Done()
     }
+    gq.wakeHeavy()
     if gq.queue.Len() > gq.maxlength {
         gq.maxlength = gq.queue.Len()
     }
+}
+
+//  Return the function the Dispatch should run for t, depending on which
+//  of the ResultTask and ContextTask interfaces t satisfies.
+func taskRunner(t queues.Task) func(context.Context, int64) (interface{}, error) {
+    switch t.(type) {
+    case ResultTask:
+        return t.(ResultTask).ResultFunc()
+    case ContextTask:
+        var f = t.(ContextTask).ContextFunc()
+        return func(ctx context.Context, id int64) (interface{}, error) {
+            f(ctx, id)
+            return nil, nil
+        }
+    }
+    var f = t.Func()
+    return func(ctx context.Context, id int64) (interface{}, error) {
+        f(id)
+        return nil, nil
+    }
+}
+
+//  The error of a Future whose task was removed from the queue.
+var ErrRemoved = errors.New("dispatch: task removed")
+
+//  Remove the task with a given id from the queue. Returns true if the
+//  task was still waiting in the queue, or waiting to be retried, in which
+//  case it will never run and its Future resolves with ErrRemoved.
+func (gq *Dispatch) Remove(id int64) bool {
+    gq.qLock.Lock()
+    var task = gq.queue.Remove(id)
+    if w := gq.cancelRetry(id); w != nil {
+        task = w
+    }
+    if task != nil {
+        gq.wakeHeavy()
+    }
+    var notify = gq.resized()
     gq.qLock.Unlock()
+    notify()
+    if task == nil {
+        return false
+    }
+    gq.drop(task.(*dispatchTaskWrapper), ErrRemoved)
+    return true
+}
 
-    return id
+//  Remove every queued task for which f returns true, and return their
+//  ids. The queue is locked while f is called, so f must not call any
+//  methods of gq. See gq.Remove(id).
+func (gq *Dispatch) RemoveIf(f func(queues.RegisteredTask) bool) []int64 {
+    return gq.removeIf(f, ErrRemoved)
+}
+
+//  Remove tasks for gq.RemoveIf(f), resolving their Futures with err.
+func (gq *Dispatch) removeIf(f func(queues.RegisteredTask) bool, err error) []int64 {
+    gq.qLock.Lock()
+    var removed = gq.queue.RemoveIf(f)
+    for id, r := range gq.retrying {
+        if f(r.w) {
+            removed = append(removed, gq.cancelRetry(id))
+        }
+    }
+    if len(removed) > 0 {
+        gq.wakeHeavy()
+    }
+    var notify = gq.resized()
+    gq.qLock.Unlock()
+    notify()
+    var ids = make([]int64, len(removed))
+    for i, task := range removed {
+        ids[i] = task.Id()
+        gq.drop(task.(*dispatchTaskWrapper), err)
+    }
+    return ids
+}
+
+//  Resolve the Future of a task which will not run again and stop
+//  counting it as in flight. The task's original function is restored so
+//  that it may be enqueued again.
+func (gq *Dispatch) done(w *dispatchTaskWrapper, value interface{}, err error) {
+    gq.restore(w)
+    w.future.resolve(value, err)
+    gq.inflight.Done()
+}
+
+//  Give a task which will not run again its original function. Once this
+//  is done the task may be enqueued again, so w must not touch it.
+func (gq *Dispatch) restore(w *dispatchTaskWrapper) {
+    if !w.restored {
+        w.restored = true
+        w.t.SetFunc(w.f)
+    }
+}
+
+//  Resolve a task which ran and will not run again, recording it in the
+//  report of a Shutdown() in progress.
+func (gq *Dispatch) resolve(w *dispatchTaskWrapper, value interface{}, err error) {
+    gq.pLock.Lock()
+    if gq.report != nil {
+        if err == nil {
+            gq.report.Ran = append(gq.report.Ran, w.id)
+        } else {
+            gq.report.Failed = append(gq.report.Failed, w.id)
+        }
+    }
+    gq.pLock.Unlock()
+    gq.done(w, value, err)
+}
+
+//  Give back the slots held by a task and wake gq.next() if it is waiting
+//  on the concurrency limit. The task w is nil when the slot was released
+//  before a task was dequeued.
+func (gq *Dispatch) release(w *dispatchTaskWrapper) {
+    gq.pLock.Lock()
+    if w != nil {
+        gq.processing -= w.weight
+        delete(gq.running, w.id)
+        gq.slots[w.slot] = false
+    } else {
+        gq.processing--
+    }
+    if gq.waitingToRun {
+        gq.waitingToRun = false
+        gq.nextWait.Done()
+    }
+    gq.pLock.Unlock()
+}
+
+//  Claim the lowest slot index not used by a running task. The caller must
+//  hold gq.pLock.
+func (gq *Dispatch) takeSlot() int {
+    for i, used := range gq.slots {
+        if !used {
+            gq.slots[i] = true
+            return i
+        }
+    }
+    gq.slots = append(gq.slots, true)
+    return len(gq.slots) - 1
 }
 
 //  Stop the queue after gq.Start() has been called. Any goroutines which
 //  have not already been dequeued will not be executed until gq.Start()
 //  is called again.
 func (gq *Dispatch) Stop() {
+    gq.stop(nil)
+}
+
+//  Stop the queue if kill is the channel of the current gq.Start() call.
+//  A nil kill stops any running gq.Start().
+func (gq *Dispatch) stop(kill chan bool) {
     // Lock out Start() and queue ops for the entire call.
     gq.startLock.Lock()
     defer gq.startLock.Unlock()
     gq.qLock.Lock()
     defer gq.qLock.Unlock()
 
-    if !gq.started {
+    if !gq.started || (kill != nil && kill != gq.kill) {
         return
     }
 
     // Clear channel flags and close channels, stoping further processing.
+    // The kill channel must be closed before any flags are inspected so
+    // that gq.Start() and gq.next() see it before they wait again.
     close(gq.kill)
     gq.started = false
     if gq.waitingOnQ {
         gq.waitingOnQ = false
         gq.restart.Done()
     }
+    gq.pLock.Lock()
     if gq.waitingToRun {
         gq.waitingToRun = false
         gq.nextWait.Done()
     }
+    gq.pLock.Unlock()
+}
+
+//  Stop starting tasks without stopping the Dispatch. Running tasks are
+//  unaffected and gq.Enqueue() continues to accept tasks, which wait in
+//  the queue until gq.Resume() is called. Shutting down in Drain mode
+//  resumes a paused Dispatch.
+func (gq *Dispatch) Pause() {
+    gq.qLock.Lock()
+    gq.paused = true
+    gq.qLock.Unlock()
+}
+
+//  Continue starting tasks after a call to gq.Pause().
+func (gq *Dispatch) Resume() {
+    gq.qLock.Lock()
+    gq.resume()
+    gq.qLock.Unlock()
+}
+
+//  Unpause the Dispatch for gq.Resume(). The caller must hold gq.qLock.
+func (gq *Dispatch) resume() {
+    gq.paused = false
+    if gq.waitingOnQ {
+        gq.waitingOnQ = false
+        gq.restart.Done()
+    }
+}
+
+//  Returns true if gq.Pause() has been called without a matching
+//  gq.Resume().
+func (gq *Dispatch) IsPaused() bool {
+    gq.qLock.Lock()
+    defer gq.qLock.Unlock()
+    return gq.paused
+}
+
+//  Returns true if the kill channel has been closed.
+func killed(kill chan bool) bool {
+    select {
+    case <-kill:
+        return true
+    default:
+    }
+    return false
 }
 
-//  Start the next task in the queue. It's assumed that the queue is non-
-//  empty. Furthermore, there should only be one goroutine in this method
-//  (for this object) at a time. Both conditions are enforced in
-//  gq.Start(), which calls gq.next() exclusively.
-func (gq *Dispatch) next() {
+//  Start the next task in the queue. There should only be one goroutine in
+//  this method (for this object) at a time. This is enforced in
+//  gq.Start(), which calls gq.next() exclusively. Nothing is started once
+//  kill has been closed, and nothing is started if the queue was emptied
+//  by gq.Remove(), or gq.Pause() was called, while waiting on the
+//  concurrency limit.
+func (gq *Dispatch) next(kill chan bool) {
     for true {
         // Attempt to start processing the file.
         gq.pLock.Lock()
-        if gq.processing >= gq.MaxGo {
+        gq.blocked.stop(time.Now())
+        gq.heavy = false
+        if killed(kill) {
+            gq.pLock.Unlock()
+            return
+        }
+        if gq.processing >= gq.maxgo {
+            var running, maxgo = gq.processing, gq.maxgo
             gq.waitingToRun = true
+            gq.blocked.start(time.Now())
             gq.nextWait.Add(1)
             gq.pLock.Unlock()
+            gq.logLimit(running, maxgo)
             gq.nextWait.Wait()
             continue
         }
@@ -231,13 +682,53 @@ func (gq *Dispatch) next() {
         gq.processing++
         gq.pLock.Unlock()
 
+        // Wait for the start rate limit, if there is one.
+        if !gq.throttle(kill) {
+            gq.release(nil)
+            return
+        }
+
         // Get an element from the queue.
         gq.qLock.Lock()
-        var wrapper = gq.queue.Dequeue().(queues.RegisteredTask)
+        if gq.queue.Len() == 0 || gq.paused {
+            gq.qLock.Unlock()
+            gq.unthrottle()
+            gq.release(nil)
+            return
+        }
+        var wrapper, need = gq.pick()
+        if wrapper == nil {
+            // Wait for running tasks to make room for a WeightedTask.
+            var wait = gq.waitHeavy(need, kill)
+            gq.qLock.Unlock()
+            gq.unthrottle()
+            if wait {
+                gq.nextWait.Wait()
+            }
+            continue
+        }
+        var notify = gq.resized()
         gq.qLock.Unlock()
+        notify()
+
+        // Drop tasks whose context finished while they were queued.
+        if err := wrapper.ctx.Err(); err != nil {
+            gq.unthrottle()
+            gq.release(nil)
+            gq.drop(wrapper, err)
+            return
+        }
+        gq.pLock.Lock()
+        gq.processing += wrapper.weight - 1
+        gq.running[wrapper.id] = wrapper
+        wrapper.slot = gq.takeSlot()
+        wrapper.attempt++
+        wrapper.started = time.Now()
+        gq.begun++
+        gq.pLock.Unlock()
+        gq.observeWait(wrapper.t.Type(), wrapper.started.Sub(wrapper.queued))
 
         // Begin processing and asyncronously return.
-        //var task = taskelm.Value.(dispatchTaskWrapper)
         var task = wrapper.Func()
         go task(wrapper.Id())
         return
@@ -261,57 +752,76 @@ func (gq *Dispatch) next() {
 //      wg.Wait()
 //      gq.Stop()
 func (gq *Dispatch) Start() {
+    gq.StartContext(context.Background())
+}
+
+//  Like gq.Start(), but processing also stops when ctx is done. As with
+//  gq.Stop(), tasks remaining in the queue are kept and will be executed
+//  if the Dispatch is started again.
+//      ctx, cancel := context.WithCancel(context.Background())
+//      go gq.StartContext(ctx)
+//      ...
+//      cancel()
+func (gq *Dispatch) StartContext(ctx context.Context) {
     // Avoid multiple gq.Start() methods and avoid race conditions.
     gq.startLock.Lock()
     if gq.started {
+        gq.startLock.Unlock()
         panic("already started")
     }
     gq.started = true
+    var kill = make(chan bool)
+    gq.kill = kill
     gq.startLock.Unlock()
 
-    // Recreate any channels that were closed by a previous Stop().
-    var inited = false
-    for !inited {
-        select {
-        case _, okKill := <-gq.kill:
-            if !okKill {
-                gq.kill = make(chan bool)
+    // Honor writes to the deprecated MaxGo field made while stopped.
+    gq.pLock.Lock()
+    if gq.MaxGo != gq.fieldMaxGo {
+        gq.maxgo = gq.MaxGo
+        gq.fieldMaxGo = gq.MaxGo
+    }
+    gq.pLock.Unlock()
+
+    // Stop when the context is done, unless gq.Stop() comes first.
+    if done := ctx.Done(); done != nil {
+        go func() {
+            select {
+            case <-done:
+                gq.stop(kill)
+            case <-kill:
             }
-        default:
-            inited = true
-        }
+        }()
     }
 
     // Process the queue
     for true {
-        select {
-        case die, ok := <-gq.kill:
-            // If something came out of this channel, we must stop.
-            if !ok {
-                // Recreate the channel.
-                gq.kill = make(chan bool)
-                return
-            }
-            if die {
-                return
-            }
-        default:
-            // Check the queue size and determine if we need to wait.
-            gq.qLock.Lock()
-            var wait = gq.queue.Len() == 0
-            if gq.waitingOnQ = wait; wait {
-                gq.restart.Add(1)
-            }
+        // Check the queue size and determine if we need to wait. The kill
+        // channel is closed while gq.Stop() holds the queue lock.
+        gq.qLock.Lock()
+        gq.idle.stop(time.Now())
+        if killed(kill) {
             gq.qLock.Unlock()
+            return
+        }
+        var empty = gq.queue.Len() == 0
+        var wait = empty || gq.paused
+        if gq.waitingOnQ = wait; wait {
+            gq.restart.Add(1)
+        }
+        if empty {
+            gq.idle.start(time.Now())
+        }
+        gq.qLock.Unlock()
 
-            if wait {
-                // Wait for a restart signal from gq.Enqueue
-                gq.restart.Wait()
-            } else {
-                // Process the head of the queue and start the loop again.
-                gq.next()
-                continue
-            }
+        if empty {
+            gq.logEmpty()
+        }
+        if wait {
+            // Wait for a restart signal from gq.Enqueue or gq.Resume()
+            gq.restart.Wait()
+        } else {
+            // Process the head of the queue and start the loop again.
+            gq.next(kill)
         }
     }
 }
@@ -0,0 +1,1190 @@
+package dispatch
+/*
+ *  Filename:    dispatch_test.go
+ *  Description: Behavior tests for Dispatch.
+ *  Usage:       gotest
+ */
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "expvar"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "runtime/pprof"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+func noop(int64) {}
+
+//  A task which signals when it starts and then waits for release to be
+//  closed. Used to hold a Dispatch's slots.
+func blocker(started chan bool, release chan bool) *StdTask {
+    return NewTask(func(int64) {
+        started <- true
+        <-release
+    })
+}
+
+//  Start d in a new goroutine and wait until it is running.
+func start(d *Dispatch) {
+    go d.Start()
+    for {
+        d.startLock.Lock()
+        var started = d.started
+        d.startLock.Unlock()
+        if started {
+            return
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+//  Wait for every Future, failing the test if it takes too long.
+func waitAll(T *testing.T, fs []*Future) {
+    var timeout = time.After(10 * time.Second)
+    for _, f := range fs {
+        select {
+        case <-f.Done():
+        case <-timeout:
+            T.Fatalf("task %d did not finish", f.Id())
+        }
+    }
+}
+
+//  A FuncTask which always fails with err.
+func failing(err error) *FuncTask {
+    return NewFuncTask(func(context.Context, int64) (interface{}, error) {
+        return nil, err
+    })
+}
+
+//  A bytes.Buffer safe for use by concurrent writers.
+type syncBuffer struct {
+    lock sync.Mutex
+    buf  bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+    sb.lock.Lock()
+    defer sb.lock.Unlock()
+    return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+    sb.lock.Lock()
+    defer sb.lock.Unlock()
+    return sb.buf.String()
+}
+
+func TestStartContext(T *testing.T) {
+    var d = New(1)
+    var ctx, cancel = context.WithCancel(context.Background())
+    var stopped = make(chan bool)
+    go func() {
+        d.StartContext(ctx)
+        close(stopped)
+    }()
+    cancel()
+    select {
+    case <-stopped:
+    case <-time.After(5 * time.Second):
+        T.Fatal("StartContext did not return when its context was cancelled")
+    }
+}
+
+func TestEnqueueContext(T *testing.T) {
+    var d = New(1)
+    var started, release = make(chan bool), make(chan bool)
+    go d.Start()
+    defer d.Stop()
+    d.Enqueue(blocker(started, release))
+    <-started
+
+    // A task whose context is cancelled while queued is dropped.
+    var ctx, cancel = context.WithCancel(context.Background())
+    var ran = make(chan bool, 1)
+    var done = make(chan bool)
+    d.EnqueueContext(ctx, NewTask(func(int64) { ran <- true }))
+    d.Enqueue(NewTask(func(int64) { close(done) }))
+    cancel()
+    close(release)
+    <-done
+    select {
+    case <-ran:
+        T.Fatal("cancelled task ran")
+    default:
+    }
+
+    // Enqueueing with a done context fails.
+    if _, err := d.EnqueueContext(ctx, NewTask(noop)); err != context.Canceled {
+        T.Fatalf("EnqueueContext with a done context returned %v", err)
+    }
+
+    // ContextTasks receive the context they were enqueued with.
+    type key struct{}
+    var got = make(chan interface{}, 1)
+    ctx = context.WithValue(context.Background(), key{}, "value")
+    d.EnqueueContext(ctx, NewContextTask(func(ctx context.Context, id int64) {
+        got <- ctx.Value(key{})
+    }))
+    if v := <-got; v != "value" {
+        T.Fatalf("ContextTask got value %v", v)
+    }
+}
+
+func TestFuture(T *testing.T) {
+    var d = New(2)
+    go d.Start()
+    defer d.Stop()
+    var fail = errors.New("fail")
+    var ok = d.EnqueueFuture(context.Background(), NewFuncTask(func(ctx context.Context, id int64) (interface{}, error) {
+        return id * 2, nil
+    }))
+    var bad = d.EnqueueFuture(context.Background(), NewFuncTask(func(context.Context, int64) (interface{}, error) {
+        return nil, fail
+    }))
+    if v, err := ok.Wait(); err != nil || v.(int64) != ok.Id()*2 {
+        T.Fatalf("Wait() = %v, %v", v, err)
+    }
+    if _, err := bad.Wait(); err != fail {
+        T.Fatalf("failed task resolved with %v", err)
+    }
+    if v, err := ok.Result(); err != nil || v.(int64) != ok.Id()*2 {
+        T.Fatalf("Result() = %v, %v", v, err)
+    }
+    var pending = New(1).EnqueueFuture(context.Background(), NewTask(noop))
+    if _, err := pending.Result(); err != ErrNotDone {
+        T.Fatalf("Result() of a queued task returned %v", err)
+    }
+
+    // A task cancelled while queued resolves with the context's error.
+    var started, release = make(chan bool), make(chan bool)
+    var held = []*Future{
+        d.EnqueueFuture(context.Background(), blocker(started, release)),
+        d.EnqueueFuture(context.Background(), blocker(started, release)),
+    }
+    <-started
+    <-started
+    var ctx, cancel = context.WithCancel(context.Background())
+    var f = d.EnqueueFuture(ctx, NewTask(noop))
+    cancel()
+    close(release)
+    waitAll(T, held)
+    if _, err := f.Wait(); err != context.Canceled {
+        T.Fatalf("cancelled task resolved with %v", err)
+    }
+}
+
+func TestPanicRecover(T *testing.T) {
+    var d = New(1)
+    var handled = make(chan *PanicError, 1)
+    d.PanicHandler = func(pe *PanicError) { handled <- pe }
+    go d.Start()
+    defer d.Stop()
+    var _, err = d.EnqueueFuture(context.Background(), NewTask(func(int64) { panic("boom") })).Wait()
+    var pe *PanicError
+    if !errors.As(err, &pe) || pe.Value != "boom" || len(pe.Stack) == 0 {
+        T.Fatalf("panicking task resolved with %v", err)
+    }
+    if <-handled != pe {
+        T.Fatal("PanicHandler got a different error")
+    }
+    if _, err := d.EnqueueFuture(context.Background(), NewTask(noop)).Wait(); err != nil {
+        T.Fatalf("task after a panic failed: %v", err)
+    }
+}
+
+func TestRemove(T *testing.T) {
+    var d = New(1)
+    var started, release = make(chan bool), make(chan bool)
+    go d.Start()
+    defer d.Stop()
+    d.Enqueue(blocker(started, release))
+    <-started
+    var f2 = d.EnqueueFuture(context.Background(), NewTask(noop))
+    var f3 = d.EnqueueFuture(context.Background(), NewTask(noop))
+    var f4 = d.EnqueueFuture(context.Background(), NewTask(noop))
+    if !d.Remove(f2.Id()) || d.Remove(f2.Id()) {
+        T.Fatal("Remove() should succeed exactly once")
+    }
+    var ids = d.RemoveIf(func(task queues.RegisteredTask) bool {
+        return task.Id() == f3.Id()
+    })
+    if len(ids) != 1 || ids[0] != f3.Id() {
+        T.Fatalf("RemoveIf() removed %v", ids)
+    }
+    close(release)
+    for _, f := range []*Future{f2, f3} {
+        if _, err := f.Wait(); err != ErrRemoved {
+            T.Fatalf("removed task resolved with %v", err)
+        }
+    }
+    if _, err := f4.Wait(); err != nil {
+        T.Fatalf("remaining task failed: %v", err)
+    }
+}
+
+func TestShutdownDrain(T *testing.T) {
+    var d = New(2)
+    start(d)
+    for i := 0 ; i < 10 ; i++ {
+        d.Enqueue(NewTask(func(int64) { time.Sleep(time.Millisecond) }))
+    }
+    var report, err = d.Shutdown(context.Background(), Drain)
+    if err != nil || len(report.Ran) != 10 || len(report.Dropped) != 0 {
+        T.Fatalf("Shutdown() = %+v, %v", report, err)
+    }
+    if _, err := d.EnqueueContext(context.Background(), NewTask(noop)); err != ErrClosed {
+        T.Fatalf("Enqueue after Shutdown() returned %v", err)
+    }
+}
+
+func TestShutdownNotStarted(T *testing.T) {
+    var d = New(1)
+    var f = d.EnqueueFuture(context.Background(), NewTask(noop))
+    if _, err := d.Shutdown(context.Background(), Drain); err != ErrNotStarted {
+        T.Fatalf("Shutdown() of a stopped Dispatch returned %v", err)
+    }
+    // Nothing was done, so the Dispatch can still be started and drained.
+    start(d)
+    if _, err := d.Shutdown(context.Background(), Drain); err != nil {
+        T.Fatal(err)
+    }
+    if _, err := f.Result(); err != nil {
+        T.Fatalf("queued task resolved with %v", err)
+    }
+}
+
+func TestShutdownAbort(T *testing.T) {
+    var d = New(1)
+    var started, release = make(chan bool), make(chan bool)
+    go d.Start()
+    d.Enqueue(blocker(started, release))
+    <-started
+    var f = d.EnqueueFuture(context.Background(), NewTask(noop))
+    go func() {
+        time.Sleep(10 * time.Millisecond)
+        close(release)
+    }()
+    var report, err = d.Shutdown(context.Background(), Abort)
+    if err != nil || len(report.Dropped) != 1 || len(report.Ran) != 1 {
+        T.Fatalf("Shutdown() = %+v, %v", report, err)
+    }
+    if _, err := f.Wait(); err != ErrClosed {
+        T.Fatalf("discarded task resolved with %v", err)
+    }
+}
+
+func TestShutdownDeadline(T *testing.T) {
+    var d = New(1)
+    var started, release = make(chan bool), make(chan bool)
+    defer close(release)
+    go d.Start()
+    d.Enqueue(blocker(started, release))
+    <-started
+    d.Enqueue(NewTask(noop))
+    var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    var report, err = d.Shutdown(ctx, Drain)
+    if err != context.DeadlineExceeded || len(report.Dropped) != 1 || len(report.Running) != 1 {
+        T.Fatalf("Shutdown() = %+v, %v", report, err)
+    }
+}
+
+//  A task with a short timeout.
+type timeoutTask struct {
+    *CtxTask
+}
+
+func (timeoutTask) Timeout() time.Duration { return 5 * time.Millisecond }
+
+func TestTimeout(T *testing.T) {
+    var d = New(1)
+    go d.Start()
+    defer d.Stop()
+    var release = make(chan bool)
+    var returned = make(chan bool)
+    var f = d.EnqueueFuture(context.Background(), timeoutTask{NewContextTask(func(ctx context.Context, id int64) {
+        <-ctx.Done()
+        <-release
+        close(returned)
+    })})
+    if _, err := f.Wait(); err != ErrTimeout || !errors.Is(err, context.DeadlineExceeded) {
+        T.Fatalf("timed out task resolved with %v", err)
+    }
+    if n := d.Stuck(); n != 1 {
+        T.Fatalf("Stuck() = %d, expected 1", n)
+    }
+    // The slot was given back, so other tasks still run.
+    if _, err := d.EnqueueFuture(context.Background(), NewTask(noop)).Wait(); err != nil {
+        T.Fatalf("task after a timeout failed: %v", err)
+    }
+    close(release)
+    <-returned
+    waitUnstuck(T, d)
+}
+
+//  Wait for every stuck task of d to return.
+func waitUnstuck(T *testing.T, d *Dispatch) {
+    for i := 0 ; d.Stuck() != 0 ; i++ {
+        if i > 1000 {
+            T.Fatal("stuck task never counted as returned")
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestRetry(T *testing.T) {
+    var d = New(2)
+    d.Retry = &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Jitter: 0.5}
+    go d.Start()
+    defer d.Stop()
+    var attempts []int
+    var f = d.EnqueueFuture(context.Background(), NewFuncTask(func(ctx context.Context, id int64) (interface{}, error) {
+        attempts = append(attempts, Attempt(ctx))
+        if Attempt(ctx) < 3 {
+            return nil, errors.New("fail")
+        }
+        return "ok", nil
+    }))
+    if v, err := f.Wait(); err != nil || v != "ok" {
+        T.Fatalf("retried task resolved with %v, %v", v, err)
+    }
+    if len(attempts) != 3 || attempts[2] != 3 {
+        T.Fatalf("attempts %v", attempts)
+    }
+
+    // A task waiting out its backoff can be removed.
+    d.Retry = &RetryPolicy{MaxAttempts: 2, Backoff: time.Hour}
+    var ran = make(chan bool, 1)
+    f = d.EnqueueFuture(context.Background(), NewFuncTask(func(context.Context, int64) (interface{}, error) {
+        ran <- true
+        return nil, errors.New("fail")
+    }))
+    <-ran
+    for i := 0 ; !d.Remove(f.Id()) ; i++ {
+        if i > 1000 {
+            T.Fatal("could not remove a task waiting to be retried")
+        }
+        time.Sleep(time.Millisecond)
+    }
+    if _, err := f.Wait(); err != ErrRemoved {
+        T.Fatalf("removed task resolved with %v", err)
+    }
+}
+
+func TestDeadLetters(T *testing.T) {
+    var path = filepath.Join(T.TempDir(), "dead.jsonl")
+    var sink, err = NewFileDeadLetters(path, 1)
+    if err != nil {
+        T.Fatal(err)
+    }
+    var d = New(2)
+    d.DeadLetters = sink
+    d.Retry = &RetryPolicy{MaxAttempts: 2}
+    go d.Start()
+    defer d.Stop()
+    d.EnqueueFuture(context.Background(), failing(errors.New("fail"))).Wait()
+    d.EnqueueFuture(context.Background(), NewTask(func(int64) { panic("boom") })).Wait()
+    sink.Close()
+
+    // Only the last letter is kept in memory, but both are in the file.
+    var letters = sink.Take()
+    if len(letters) != 1 || letters[0].Panic != "boom" {
+        T.Fatalf("letters %+v", letters)
+    }
+    var data, _ = os.ReadFile(path)
+    if n := strings.Count(string(data), "\n"); n != 2 {
+        T.Fatalf("%d letters in the file, expected 2", n)
+    }
+
+    var mem = NewMemoryDeadLetters(0)
+    d.DeadLetters = mem
+    var fail = true
+    var succeeded = make(chan bool, 1)
+    d.EnqueueFuture(context.Background(), NewFuncTask(func(context.Context, int64) (interface{}, error) {
+        if fail {
+            return nil, errors.New("fail")
+        }
+        succeeded <- true
+        return nil, nil
+    })).Wait()
+    fail = false
+    var ids, rerr = d.Redrive(mem.Take()...)
+    if rerr != nil || len(ids) != 1 {
+        T.Fatalf("Redrive() = %v, %v", ids, rerr)
+    }
+    select {
+    case <-succeeded:
+    case <-time.After(5 * time.Second):
+        T.Fatal("redriven task did not succeed")
+    }
+
+    // A MemoryDeadLetters with a limit drops its oldest letters.
+    var limited = NewMemoryDeadLetters(2)
+    for i := 1 ; i <= 3 ; i++ {
+        limited.Put(DeadLetter{Id: int64(i)})
+    }
+    if letters := limited.Letters(); len(letters) != 2 || letters[0].Id != 2 {
+        T.Fatalf("letters %+v", letters)
+    }
+}
+
+//  A DeadLetterSink which redrives each task as soon as it is put.
+type redriveSink struct {
+    d *Dispatch
+}
+
+func (s redriveSink) Put(dl DeadLetter) error {
+    var _, err = s.d.Redrive(dl)
+    return err
+}
+
+func TestDeadLettersRedriveFromPut(T *testing.T) {
+    var d = New(1)
+    d.DeadLetters = redriveSink{d}
+    go d.Start()
+    defer d.Stop()
+    var runs int32
+    var succeeded = make(chan bool, 1)
+    d.Enqueue(NewFuncTask(func(context.Context, int64) (interface{}, error) {
+        if atomic.AddInt32(&runs, 1) == 1 {
+            return nil, errors.New("fail")
+        }
+        succeeded <- true
+        return nil, nil
+    }))
+    select {
+    case <-succeeded:
+    case <-time.After(5 * time.Second):
+        T.Fatal("redriven task did not succeed")
+    }
+    // The redriven task gave its slot back.
+    waitAll(T, []*Future{d.EnqueueFuture(context.Background(), NewTask(noop))})
+    if s := d.Stats(); s.Running != 0 {
+        T.Fatalf("%d tasks running", s.Running)
+    }
+}
+
+func TestSetMaxGo(T *testing.T) {
+    var d = New(1)
+    var started, release = make(chan bool, 3), make(chan bool)
+    go d.Start()
+    defer d.Stop()
+    defer close(release)
+    for i := 0 ; i < 3 ; i++ {
+        d.Enqueue(blocker(started, release))
+    }
+    <-started
+    select {
+    case <-started:
+        T.Fatal("started past the limit")
+    case <-time.After(10 * time.Millisecond):
+    }
+    d.SetMaxGo(3)
+    <-started
+    <-started
+    if n := d.CurrentMaxGo(); n != 3 {
+        T.Fatalf("CurrentMaxGo() = %d", n)
+    }
+}
+
+func TestPauseResume(T *testing.T) {
+    var d = New(2)
+    go d.Start()
+    defer d.Stop()
+    d.Pause()
+    var fs []*Future
+    for i := 0 ; i < 5 ; i++ {
+        fs = append(fs, d.EnqueueFuture(context.Background(), NewTask(noop)))
+    }
+    time.Sleep(10 * time.Millisecond)
+    if !d.IsPaused() || d.Len() != 5 {
+        T.Fatalf("paused Dispatch has %d tasks queued", d.Len())
+    }
+    d.Resume()
+    waitAll(T, fs)
+    if d.IsPaused() {
+        T.Fatal("IsPaused() after Resume()")
+    }
+}
+
+func TestStats(T *testing.T) {
+    var d = New(1)
+    go d.Start()
+    defer d.Stop()
+    d.Enqueue(NewTask(noop))
+    d.Enqueue(NewTask(func(int64) { panic(1) }))
+    d.EnqueueFuture(context.Background(), failing(errors.New("fail"))).Wait()
+    var s = d.Stats()
+    if s.Enqueued != 3 || s.Started != 3 || s.Completed != 1 || s.Failed != 2 || s.Panicked != 1 || s.MaxGo != 1 {
+        T.Fatalf("%+v", s)
+    }
+}
+
+func TestLatency(T *testing.T) {
+    var h Histogram
+    for i := 1 ; i <= 100 ; i++ {
+        h.Observe(time.Duration(i) * time.Millisecond)
+    }
+    if p50 := h.Quantile(0.5); p50 < 50*time.Millisecond || p50 > 60*time.Millisecond {
+        T.Fatalf("median %v", p50)
+    }
+    if h.Count() != 100 || h.Max() != 100*time.Millisecond {
+        T.Fatalf("count %d, max %v", h.Count(), h.Max())
+    }
+
+    var d = New(1)
+    go d.Start()
+    d.EnqueueFuture(context.Background(), NewTask(func(int64) { time.Sleep(2 * time.Millisecond) })).Wait()
+    d.Stop()
+    var l = d.Latency()["StdTask"]
+    if l.Run.Count() != 1 || l.Wait.Count() != 1 || l.Run.Max() < 2*time.Millisecond {
+        T.Fatalf("latency %+v", l)
+    }
+}
+
+func TestWriteMetrics(T *testing.T) {
+    var d = New(2)
+    d.Name = `a"b`
+    go d.Start()
+    d.EnqueueFuture(context.Background(), NewTask(noop)).Wait()
+    d.Stop()
+    var buf bytes.Buffer
+    if err := WriteMetrics(&buf, d); err != nil {
+        T.Fatal(err)
+    }
+    var text = buf.String()
+    for _, line := range []string{
+        `dispatch_enqueued_total{dispatch="a\"b"} 1`,
+        `dispatch_task_run_seconds_bucket{dispatch="a\"b",task_type="StdTask",le="+Inf"} 1`,
+    } {
+        if !strings.Contains(text, line+"\n") {
+            T.Fatalf("missing %q in\n%s", line, text)
+        }
+    }
+    if !strings.HasSuffix(text, "# EOF\n") {
+        T.Fatal("missing # EOF")
+    }
+
+    // Unnamed Dispatches are told apart by their position.
+    buf.Reset()
+    if err := WriteMetrics(&buf, New(1), d, New(1)); err != nil {
+        T.Fatal(err)
+    }
+    for _, name := range []string{"dispatch 1", "dispatch 3"} {
+        if !strings.Contains(buf.String(), `dispatch_enqueued_total{dispatch="`+name+`"} 0`) {
+            T.Fatalf("no metrics for %q", name)
+        }
+    }
+    buf.Reset()
+    if err := WriteMetrics(&buf, d, d); err == nil || buf.Len() != 0 {
+        T.Fatal("wrote metrics for two Dispatches with the same name")
+    }
+}
+
+func TestExpvar(T *testing.T) {
+    var d = New(2)
+    if err := d.Publish("TestExpvar"); err != nil {
+        T.Fatal(err)
+    }
+    if err := New(1).Publish("TestExpvar"); err == nil {
+        T.Fatal("published a name twice")
+    }
+    var m map[string]map[string]interface{}
+    if err := json.Unmarshal([]byte(expvar.Get(ExpvarName).String()), &m); err != nil {
+        T.Fatal(err)
+    }
+    if m["TestExpvar"]["max_go"] != float64(2) {
+        T.Fatalf("published %v", m["TestExpvar"])
+    }
+    d.Unpublish()
+    var e = New(1)
+    if err := e.Publish("TestExpvar"); err != nil {
+        T.Fatal(err)
+    }
+    e.Unpublish()
+
+    // An unnamed Dispatch needs a name to be published.
+    if err := New(1).Publish(""); err == nil {
+        T.Fatal("published an unnamed Dispatch")
+    }
+    var named = New(1)
+    named.Name = "TestExpvarNamed"
+    if err := named.Publish(""); err != nil {
+        T.Fatal(err)
+    }
+    named.Unpublish()
+}
+
+func TestHooks(T *testing.T) {
+    var d = New(1)
+    var lock sync.Mutex
+    var kinds = make(map[EventKind]int)
+    var record = func(e TaskEvent) {
+        lock.Lock()
+        kinds[e.Kind]++
+        lock.Unlock()
+    }
+    d.AddHooks(Hooks{OnEnqueue: record, OnStart: record, OnFinish: record, OnDrop: record})
+    var f = d.EnqueueFuture(context.Background(), NewTask(noop))
+    d.Remove(d.Enqueue(NewTask(noop)))
+    go d.Start()
+    f.Wait()
+    d.Stop()
+    lock.Lock()
+    defer lock.Unlock()
+    if kinds[EventEnqueue] != 2 || kinds[EventStart] != 1 || kinds[EventFinish] != 1 || kinds[EventDrop] != 1 {
+        T.Fatalf("events %v", kinds)
+    }
+}
+
+func TestLogger(T *testing.T) {
+    var buf syncBuffer
+    var d = New(1)
+    d.Name = "logged"
+    d.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+    go d.Start()
+    d.Enqueue(NewTask(noop))
+    d.EnqueueFuture(context.Background(), NewTask(func(int64) { panic("boom") })).Wait()
+    d.Stop()
+    var text = buf.String()
+    for _, s := range []string{"task enqueued", "task started", "task finished", "task panicked", "dispatch=logged"} {
+        if !strings.Contains(text, s) {
+            T.Fatalf("missing %q in\n%s", s, text)
+        }
+    }
+}
+
+func TestPprofLabels(T *testing.T) {
+    var d = New(1)
+    go d.Start()
+    defer d.Stop()
+    var v, _ = d.EnqueueFuture(context.Background(), NewFuncTask(func(ctx context.Context, id int64) (interface{}, error) {
+        var typ, _ = pprof.Label(ctx, "task_type")
+        return typ, nil
+    })).Wait()
+    if v != "FuncTask" {
+        T.Fatalf("task_type label %v", v)
+    }
+}
+
+func TestTimeline(T *testing.T) {
+    var d = New(2)
+    var tl = NewTimeline()
+    tl.Attach(d)
+    go d.Start()
+    var fs []*Future
+    for i := 0 ; i < 5 ; i++ {
+        fs = append(fs, d.EnqueueFuture(context.Background(), NewTask(noop)))
+    }
+    waitAll(T, fs)
+    d.Stop()
+    var buf bytes.Buffer
+    if _, err := tl.WriteTo(&buf); err != nil {
+        T.Fatal(err)
+    }
+    var trace struct {
+        TraceEvents []struct {
+            Ph  string
+            Tid int
+        }
+    }
+    if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+        T.Fatal(err)
+    }
+    var spans = 0
+    for _, e := range trace.TraceEvents {
+        if e.Ph == "X" {
+            spans++
+            if e.Tid < 1 || e.Tid > 2 {
+                T.Fatalf("span on thread %d with 2 slots", e.Tid)
+            }
+        }
+    }
+    if spans != 5 {
+        T.Fatalf("%d spans, expected 5", spans)
+    }
+}
+
+func TestAuditLog(T *testing.T) {
+    var buf syncBuffer
+    var d = New(1)
+    d.Name = "audited"
+    var al = NewAuditLog(&buf)
+    al.Attach(d)
+    go d.Start()
+    d.EnqueueFuture(context.Background(), failing(errors.New("fail"))).Wait()
+    d.Stop()
+    if err := al.Close(); err != nil {
+        T.Fatal(err)
+    }
+    if err := al.Close(); err != ErrAuditClosed {
+        T.Fatalf("second Close() returned %v", err)
+    }
+    var events []string
+    var scanner = bufio.NewScanner(strings.NewReader(buf.String()))
+    for scanner.Scan() {
+        var rec map[string]interface{}
+        if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+            T.Fatalf("%v: %q", err, scanner.Text())
+        }
+        if rec["dispatch"] != "audited" || rec["type"] != "FuncTask" {
+            T.Fatalf("record %v", rec)
+        }
+        events = append(events, rec["event"].(string))
+    }
+    if len(events) != 3 || events[2] != "finish" {
+        T.Fatalf("events %v", events)
+    }
+}
+
+func TestQueuedRunning(T *testing.T) {
+    var d = NewCustom(1, queues.NewPriorityQueue())
+    var started, release = make(chan bool), make(chan bool)
+    d.Enqueue(&queues.PTask{F: func(int64) { started <- true; <-release }, P: 0})
+    go d.Start()
+    defer d.Stop()
+    defer close(release)
+    <-started
+    for _, key := range []float64{5, 3, 4} {
+        d.Enqueue(&queues.PTask{F: noop, P: key})
+    }
+    var queued = d.Queued()
+    if len(queued) != 3 || !queued[0].Prioritized || queued[0].Key != 3 || queued[2].Key != 5 {
+        T.Fatalf("Queued() = %+v", queued)
+    }
+    var running = d.Running()
+    if len(running) != 1 || running[0].Id != 1 || running[0].Started.IsZero() {
+        T.Fatalf("Running() = %+v", running)
+    }
+}
+
+func TestCapacity(T *testing.T) {
+    var d = New(1)
+    d.Capacity = 2
+    d.HighWater, d.LowWater = 2, 0
+    var high, low int32
+    d.OnHighWater = func(int) { atomic.AddInt32(&high, 1) }
+    d.OnLowWater = func(int) { atomic.AddInt32(&low, 1) }
+    d.Enqueue(NewTask(noop))
+    d.Enqueue(NewTask(noop))
+    if _, err := d.TryEnqueue(NewTask(noop)); err != ErrFull {
+        T.Fatalf("TryEnqueue() on a full queue returned %v", err)
+    }
+    var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Millisecond)
+    defer cancel()
+    if _, err := d.EnqueueContext(ctx, NewTask(noop)); err != context.DeadlineExceeded {
+        T.Fatalf("EnqueueContext() on a full queue returned %v", err)
+    }
+
+    // A blocked Enqueue succeeds once the queue has room.
+    var enqueued = make(chan *Future)
+    go func() { enqueued <- d.EnqueueFuture(context.Background(), NewTask(noop)) }()
+    go d.Start()
+    defer d.Stop()
+    var f = <-enqueued
+    if _, err := f.Wait(); err != nil {
+        T.Fatal(err)
+    }
+    if atomic.LoadInt32(&high) == 0 || atomic.LoadInt32(&low) == 0 {
+        T.Fatalf("watermark callbacks called %d and %d times", high, low)
+    }
+
+    var r = New(1)
+    r.Capacity = 1
+    r.FullPolicy = FullReject
+    r.Enqueue(NewTask(noop))
+    if id := r.Enqueue(NewTask(noop)); id != 0 {
+        T.Fatal("FullReject accepted a task")
+    }
+}
+
+//  A task with a fixed deadline.
+type deadlineTask struct {
+    *StdTask
+    deadline time.Time
+}
+
+func (t deadlineTask) Deadline() time.Time { return t.deadline }
+
+func TestShedding(T *testing.T) {
+    var d = New(1)
+    d.Capacity = 2
+    d.FullPolicy = FullDropOldest
+    var oldest = d.EnqueueFuture(context.Background(), NewTask(noop))
+    d.Enqueue(NewTask(noop))
+    if d.Enqueue(NewTask(noop)) == 0 {
+        T.Fatal("FullDropOldest refused a task")
+    }
+    if _, err := oldest.Wait(); err != ErrShed {
+        T.Fatalf("oldest task resolved with %v", err)
+    }
+
+    var p = NewCustom(1, queues.NewPriorityQueue())
+    p.Capacity = 2
+    p.FullPolicy = FullEvictLowest
+    p.Enqueue(&queues.PTask{F: noop, P: 1})
+    var lowest = p.EnqueueFuture(context.Background(), &queues.PTask{F: noop, P: 5})
+    if p.Enqueue(&queues.PTask{F: noop, P: 9}) != 0 {
+        T.Fatal("FullEvictLowest accepted the lowest priority task")
+    }
+    if p.Enqueue(&queues.PTask{F: noop, P: 3}) == 0 {
+        T.Fatal("FullEvictLowest refused a higher priority task")
+    }
+    if _, err := lowest.Wait(); err != ErrShed {
+        T.Fatalf("evicted task resolved with %v", err)
+    }
+
+    var e = New(1)
+    e.Capacity = 1
+    e.FullPolicy = FullDropExpired
+    var expired = e.EnqueueFuture(context.Background(), deadlineTask{NewTask(noop), time.Now().Add(time.Millisecond)})
+    if e.Enqueue(NewTask(noop)) != 0 {
+        T.Fatal("FullDropExpired dropped a task before its deadline")
+    }
+    time.Sleep(2 * time.Millisecond)
+    if e.Enqueue(NewTask(noop)) == 0 {
+        T.Fatal("FullDropExpired refused a task")
+    }
+    if _, err := expired.Wait(); err != ErrTimeout {
+        T.Fatalf("expired task resolved with %v", err)
+    }
+}
+
+func TestLimiters(T *testing.T) {
+    var aimd = &AIMDLimiter{Min: 2, Max: 10}
+    var n = 4
+    for i := 0 ; i < 20 ; i++ {
+        n = aimd.Observe(LimitSample{RunTime: time.Millisecond, Running: n, Limit: n})
+    }
+    if n != 10 {
+        T.Fatalf("AIMD grew to %d, expected 10", n)
+    }
+    for i := 0 ; i < 50 ; i++ {
+        n = aimd.Observe(LimitSample{Failed: true, Running: n, Limit: n})
+    }
+    if n != 2 {
+        T.Fatalf("AIMD shrank to %d, expected 2", n)
+    }
+
+    var gradient = &GradientLimiter{Min: 1, Max: 100}
+    n = 4
+    for i := 0 ; i < 200 ; i++ {
+        n = gradient.Observe(LimitSample{RunTime: time.Millisecond, Running: n, Limit: n})
+    }
+    if n < 50 {
+        T.Fatalf("gradient grew only to %d", n)
+    }
+    for i := 0 ; i < 200 ; i++ {
+        n = gradient.Observe(LimitSample{RunTime: 50 * time.Millisecond, Running: n, Limit: n})
+    }
+    if n > 30 {
+        T.Fatalf("gradient shrank only to %d", n)
+    }
+
+    // The chosen limit shows in the stats.
+    var d = New(2)
+    d.Limiter = &AIMDLimiter{Min: 1, Max: 8}
+    go d.Start()
+    defer d.Stop()
+    var fs []*Future
+    for i := 0 ; i < 50 ; i++ {
+        fs = append(fs, d.EnqueueFuture(context.Background(), failing(errors.New("fail"))))
+    }
+    waitAll(T, fs)
+    if s := d.Stats(); s.MaxGo != 1 {
+        T.Fatalf("limit %d after failures, expected 1", s.MaxGo)
+    }
+}
+
+func TestRate(T *testing.T) {
+    var d = New(10)
+    d.SetRate(200, 1)
+    var fs []*Future
+    for i := 0 ; i < 11 ; i++ {
+        fs = append(fs, d.EnqueueFuture(context.Background(), NewTask(noop)))
+    }
+    var start = time.Now()
+    go d.Start()
+    defer d.Stop()
+    waitAll(T, fs)
+    if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+        T.Fatalf("11 tasks at 200/s started in %v", elapsed)
+    }
+    var s = d.Stats()
+    if s.Rate != 200 || s.Burst != 1 || s.ThrottledTime == 0 {
+        T.Fatalf("%+v", s)
+    }
+
+    // Removing the limit wakes a throttled Dispatch.
+    d.SetRate(0.01, 1)
+    d.Enqueue(NewTask(noop))
+    var f = d.EnqueueFuture(context.Background(), NewTask(noop))
+    time.Sleep(5 * time.Millisecond)
+    d.SetRate(0, 0)
+    waitAll(T, []*Future{f})
+}
+
+//  A task with a weight.
+type weightedTask struct {
+    *StdTask
+    weight int
+}
+
+func (t weightedTask) Weight() int { return t.weight }
+
+//  A prioritized task with a weight.
+type weightedPTask struct {
+    *queues.PTask
+    weight int
+}
+
+func (t weightedPTask) Weight() int { return t.weight }
+
+//  The weights of the tasks run by startOrder, in the order they are to
+//  start. The third is too heavy to start while the first two run.
+var startWeights = []int{2, 1, 9, 1, 1, 3}
+
+//  Run tasks with startWeights through a Dispatch with 4 slots and return
+//  the indices of their weights in the order the tasks started. With a
+//  priority queue the tasks are enqueued in reverse, keyed by index, so
+//  the queue must put them back in order.
+func startOrder(T *testing.T, policy WeightPolicy, prioritized bool) []int {
+    var d = New(4)
+    if prioritized {
+        d = NewCustom(4, queues.NewPriorityQueue())
+    }
+    d.WeightPolicy = policy
+    var lock sync.Mutex
+    var used, peak int
+    var order []int
+    var tasks = make([]queues.Task, len(startWeights))
+    for i, weight := range startWeights {
+        var i, slots = i, weight
+        if slots > 4 {
+            slots = 4
+        }
+        var f = func(int64) {
+            lock.Lock()
+            order = append(order, i)
+            used += slots
+            if used > peak {
+                peak = used
+            }
+            lock.Unlock()
+            time.Sleep(5 * time.Millisecond)
+            lock.Lock()
+            used -= slots
+            lock.Unlock()
+        }
+        if prioritized {
+            tasks[i] = weightedPTask{&queues.PTask{F: f, P: float64(i)}, weight}
+        } else {
+            tasks[i] = weightedTask{NewTask(f), weight}
+        }
+    }
+    var fs []*Future
+    for i := range tasks {
+        if prioritized {
+            i = len(tasks) - 1 - i
+        }
+        fs = append(fs, d.EnqueueFuture(context.Background(), tasks[i]))
+    }
+    go d.Start()
+    waitAll(T, fs)
+    d.Stop()
+    if peak > 4 {
+        T.Fatalf("%d slots used at once, limit 4", peak)
+    }
+    return order
+}
+
+//  The position of i in order.
+func position(order []int, i int) int {
+    for j, x := range order {
+        if x == i {
+            return j
+        }
+    }
+    return -1
+}
+
+func TestWeightWait(T *testing.T) {
+    for _, prioritized := range []bool{false, true} {
+        var order = startOrder(T, WeightWait, prioritized)
+        if position(order, 2) > position(order, 3) {
+            T.Fatalf("a light task bypassed the heavy one: %v (prioritized %v)", order, prioritized)
+        }
+    }
+}
+
+func TestWeightBypass(T *testing.T) {
+    for _, prioritized := range []bool{false, true} {
+        var order = startOrder(T, WeightBypass, prioritized)
+        if position(order, 2) < position(order, 3) {
+            T.Fatalf("no light task bypassed the heavy one: %v (prioritized %v)", order, prioritized)
+        }
+    }
+}
+
+func TestWeightRemoveHeavy(T *testing.T) {
+    var d = New(4)
+    var started, release = make(chan bool), make(chan bool)
+    go d.Start()
+    defer d.Stop()
+    defer close(release)
+    d.Enqueue(weightedTask{blocker(started, release), 2})
+    <-started
+    var heavy = d.Enqueue(weightedTask{NewTask(noop), 4})
+    var light = d.EnqueueFuture(context.Background(), NewTask(noop))
+    time.Sleep(10 * time.Millisecond)
+
+    // Removing the heavy task lets the light one start in the free slots.
+    if !d.Remove(heavy) {
+        T.Fatal("could not remove the heavy task")
+    }
+    select {
+    case <-light.Done():
+    case <-time.After(time.Second):
+        T.Fatal("light task did not start after the heavy one was removed")
+    }
+}
+
+func TestShutdownReportsRetriedTaskOnce(T *testing.T) {
+    var d = New(1)
+    d.Retry = &RetryPolicy{MaxAttempts: 3}
+    start(d)
+    d.Pause()
+    d.Enqueue(failing(errors.New("fail")))
+    d.Enqueue(NewTask(noop))
+    var report, err = d.Shutdown(context.Background(), Drain)
+    if err != nil || len(report.Failed) != 1 || len(report.Ran) != 1 {
+        T.Fatalf("Shutdown() = %+v, %v", report, err)
+    }
+}
+
+func TestShutdownDrainPaused(T *testing.T) {
+    var d = New(1)
+    start(d)
+    d.Pause()
+    var f = d.EnqueueFuture(context.Background(), NewTask(noop))
+    var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    var report, err = d.Shutdown(ctx, Drain)
+    if err != nil || len(report.Ran) != 1 {
+        T.Fatalf("Shutdown() = %+v, %v", report, err)
+    }
+    if _, err := f.Wait(); err != nil {
+        T.Fatal(err)
+    }
+}
+
+func TestTimeoutRetry(T *testing.T) {
+    // A timed out attempt may still be running when the retry starts.
+    var d = New(2)
+    d.Retry = &RetryPolicy{MaxAttempts: 3}
+    go d.Start()
+    defer d.Stop()
+    var attempts = make(chan int, 3)
+    var f = d.EnqueueFuture(context.Background(), timeoutTask{NewContextTask(func(ctx context.Context, id int64) {
+        attempts <- Attempt(ctx)
+        time.Sleep(20 * time.Millisecond)
+    })})
+    if _, err := f.Wait(); err != ErrTimeout {
+        T.Fatalf("task resolved with %v", err)
+    }
+    for i := 1 ; i <= 3 ; i++ {
+        if n := <-attempts; n != i {
+            T.Fatalf("attempt %d started as attempt %d", i, n)
+        }
+    }
+    if s := d.Stats(); s.Started != 3 || s.TimedOut != 3 {
+        T.Fatalf("%+v", s)
+    }
+    waitUnstuck(T, d)
+}
+
+//  A writer which fails slowly.
+type brokenWriter struct{}
+
+func (brokenWriter) Write(p []byte) (int, error) {
+    time.Sleep(100 * time.Microsecond)
+    return 0, errors.New("broken")
+}
+
+func TestAuditLogBrokenWriter(T *testing.T) {
+    var size = AuditBuffer
+    AuditBuffer = 4
+    defer func() { AuditBuffer = size }()
+    var d = New(64)
+    var al = NewAuditLog(brokenWriter{})
+    al.Attach(d)
+    go d.Start()
+    defer d.Stop()
+    var fs []*Future
+    for i := 0 ; i < 1000 ; i++ {
+        fs = append(fs, d.EnqueueFuture(context.Background(), NewTask(noop)))
+    }
+    waitAll(T, fs)
+    if err := al.Close(); err == nil {
+        T.Fatal("write error not reported")
+    }
+}
+
+func TestAuditLogRotation(T *testing.T) {
+    var path = filepath.Join(T.TempDir(), "audit.log")
+    var rf, err = NewRotatingFile(path, 20000, 1000)
+    if err != nil {
+        T.Fatal(err)
+    }
+    var d = New(8)
+    d.Name = "audited"
+    var al = NewAuditLog(rf)
+    al.Attach(d)
+    go d.Start()
+    var fs []*Future
+    for i := 0 ; i < 1000 ; i++ {
+        fs = append(fs, d.EnqueueFuture(context.Background(), NewTask(noop)))
+    }
+    waitAll(T, fs)
+    d.Stop()
+    if err := al.Close(); err != nil {
+        T.Fatal(err)
+    }
+    rf.Close()
+
+    // Every line of every file is a whole record.
+    var files, _ = filepath.Glob(path + "*")
+    if len(files) < 2 {
+        T.Fatal("the log was not rotated")
+    }
+    var lines = 0
+    for _, name := range files {
+        var f, err = os.Open(name)
+        if err != nil {
+            T.Fatal(err)
+        }
+        var scanner = bufio.NewScanner(f)
+        for scanner.Scan() {
+            var rec map[string]interface{}
+            if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+                T.Fatalf("%s: %v: %q", name, err, scanner.Text())
+            }
+            lines++
+        }
+        f.Close()
+    }
+    if lines != 3000 {
+        T.Fatalf("%d records, expected 3000", lines)
+    }
+}
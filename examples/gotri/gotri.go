@@ -136,13 +136,13 @@ func main() {
     ParseFlags()
 
     if opt.usefifo {
-        fifoDispatch.MaxGo = opt.numSwimmers
+        fifoDispatch.SetMaxGo(opt.numSwimmers)
     } else if opt.usevec {
-        vecDispatch.MaxGo = opt.numSwimmers
+        vecDispatch.SetMaxGo(opt.numSwimmers)
     } else if opt.usearray {
-        arrayDispatch.MaxGo = opt.numSwimmers
+        arrayDispatch.SetMaxGo(opt.numSwimmers)
     } else {
-        swimDispatch.MaxGo = opt.numSwimmers
+        swimDispatch.SetMaxGo(opt.numSwimmers)
     }
     var n = opt.numAthletes
 
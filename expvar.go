@@ -0,0 +1,98 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    expvar.go
+ *  Description: Publishing Dispatch statistics with package expvar.
+ */
+
+package dispatch
+
+import (
+    "errors"
+    "expvar"
+    "fmt"
+    "sync"
+)
+
+//  Published Dispatches appear under this expvar name, as a map from each
+//  Dispatch's published name to its statistics.
+const ExpvarName = "dispatch"
+
+var (
+    expvarOnce  sync.Once
+    expvarMap   *expvar.Map
+    expvarLock  = new(sync.Mutex)
+    expvarNames = make(map[string]*Dispatch)
+)
+
+//  Publish the Dispatch's live statistics with package expvar, so they are
+//  served at /debug/vars under ExpvarName. If name is empty gq.Name is
+//  used. An error is returned if both are empty, or if another Dispatch is
+//  published under the same name. A Dispatch is unpublished by
+//  gq.Unpublish() or gq.Shutdown().
+//      gq.Publish("crawler")
+func (gq *Dispatch) Publish(name string) error {
+    if name == "" {
+        name = gq.Name
+    }
+    if name == "" {
+        return errors.New("dispatch: no expvar name for an unnamed Dispatch")
+    }
+    expvarOnce.Do(func() {
+        expvarMap = new(expvar.Map).Init()
+        expvar.Publish(ExpvarName, expvarMap)
+    })
+
+    expvarLock.Lock()
+    defer expvarLock.Unlock()
+    if other := expvarNames[name]; other != nil && other != gq {
+        return fmt.Errorf("dispatch: expvar name %q is already published", name)
+    }
+    if gq.published != "" && gq.published != name {
+        delete(expvarNames, gq.published)
+        expvarMap.Delete(gq.published)
+    }
+    expvarNames[name] = gq
+    gq.published = name
+    expvarMap.Set(name, expvar.Func(gq.expvarStats))
+    return nil
+}
+
+//  Remove the Dispatch from expvar. It is safe to call Unpublish on a
+//  Dispatch which isn't published.
+func (gq *Dispatch) Unpublish() {
+    expvarLock.Lock()
+    defer expvarLock.Unlock()
+    if gq.published == "" {
+        return
+    }
+    if expvarNames[gq.published] == gq {
+        delete(expvarNames, gq.published)
+        expvarMap.Delete(gq.published)
+    }
+    gq.published = ""
+}
+
+//  The value published for the Dispatch, encoded as a JSON object.
+func (gq *Dispatch) expvarStats() interface{} {
+    var s = gq.Stats()
+    return map[string]interface{}{
+        "queue_length":      s.Queued,
+        "max_length":        gq.MaxLen(),
+        "running":           s.Running,
+        "stuck":             s.Stuck,
+        "max_go":            s.MaxGo,
+        "rate":              s.Rate,
+        "burst":             s.Burst,
+        "enqueued":          s.Enqueued,
+        "started":           s.Started,
+        "completed":         s.Completed,
+        "failed":            s.Failed,
+        "panicked":          s.Panicked,
+        "timed_out":         s.TimedOut,
+        "blocked_seconds":   s.BlockedTime.Seconds(),
+        "idle_seconds":      s.IdleTime.Seconds(),
+        "throttled_seconds": s.ThrottledTime.Seconds(),
+    }
+}
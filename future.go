@@ -0,0 +1,129 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    future.go
+ *  Description: Task results delivered through Future handles.
+ */
+
+package dispatch
+
+import (
+    "context"
+    "errors"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  Returned by Future.Result() when the task has not finished.
+var ErrNotDone = errors.New("dispatch: task not finished")
+
+//  A ResultTask is a queues.Task whose function produces a value and an
+//  error. The Dispatch calls ResultFunc() in place of Func() when running
+//  a ResultTask, and the results are delivered through the task's Future.
+type ResultTask interface {
+    queues.Task
+    ResultFunc() func(ctx context.Context, id int64) (interface{}, error)
+}
+
+//  A simple task producing a result, for use in a priority-less queue.
+type FuncTask struct {
+    F func(ctx context.Context, id int64) (interface{}, error)
+    f func(id int64)
+}
+
+//  Return the pointer to a newly allocated FuncTask.
+func NewFuncTask(f func(context.Context, int64) (interface{}, error)) *FuncTask {
+    t := new(FuncTask)
+    t.F = f
+    return t
+}
+
+//  Returns "FuncTask" for the queues.Task interface.
+func (ft *FuncTask) Type() string {
+    return "FuncTask"
+}
+
+//  Function modifier method for the queues.Task interface. It does not
+//  alter the function returned by ft.ResultFunc().
+func (ft *FuncTask) SetFunc(f func(id int64)) {
+    ft.f = f
+}
+
+//  Function accessor method for the queues.Task interface. Unless
+//  ft.SetFunc() has been called, the returned function calls ft.F with
+//  context.Background() and discards its results.
+func (ft *FuncTask) Func() func(id int64) {
+    if ft.f != nil {
+        return ft.f
+    }
+    var f = ft.F
+    return func(id int64) { f(context.Background(), id) }
+}
+
+//  Function accessor method for the ResultTask interface.
+func (ft *FuncTask) ResultFunc() func(ctx context.Context, id int64) (interface{}, error) {
+    return ft.F
+}
+
+//  A Future is a handle on an enqueued task. It is resolved once the
+//  task has run, or once the task is dropped without running. Tasks that
+//  are not ResultTasks resolve with a nil value.
+type Future struct {
+    id    int64
+    done  chan bool
+    value interface{}
+    err   error
+}
+
+func newFuture() *Future {
+    var f = new(Future)
+    f.done = make(chan bool)
+    return f
+}
+
+//  Set the future's results. This must be called exactly once.
+func (f *Future) resolve(value interface{}, err error) {
+    f.value = value
+    f.err = err
+    close(f.done)
+}
+
+//  The dispatch id of the task. Zero if the task was never enqueued.
+func (f *Future) Id() int64 {
+    return f.id
+}
+
+//  A channel which is closed when the future is resolved.
+func (f *Future) Done() <-chan bool {
+    return f.done
+}
+
+//  Block until the future is resolved and return the task's results.
+func (f *Future) Wait() (interface{}, error) {
+    <-f.done
+    return f.value, f.err
+}
+
+//  Return the task's results without blocking. If the future is not
+//  resolved the error is ErrNotDone.
+func (f *Future) Result() (interface{}, error) {
+    select {
+    case <-f.done:
+        return f.value, f.err
+    default:
+    }
+    return nil, ErrNotDone
+}
+
+//  Enqueue a task and return a Future for its results. If ctx is done
+//  before the task starts, the future resolves with ctx.Err(). See
+//  gq.EnqueueContext(ctx, t).
+func (gq *Dispatch) EnqueueFuture(ctx context.Context, t queues.Task) *Future {
+    var w, err = gq.enqueue(ctx, t, true)
+    if err != nil {
+        var f = newFuture()
+        f.resolve(nil, err)
+        return f
+    }
+    return w.future
+}
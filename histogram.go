@@ -0,0 +1,159 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    histogram.go
+ *  Description: Latency histograms of queue wait and run time by task type.
+ */
+
+package dispatch
+
+import (
+    "math"
+    "time"
+)
+
+const (
+    // Buckets are log-spaced, with histSteps buckets per doubling starting
+    // from histMin. The last bucket holds everything larger (~ 36 hours).
+    histMin     = time.Microsecond
+    histSteps   = 4
+    histBuckets = 37*histSteps + 1
+)
+
+//  A Histogram of durations with logarithmically spaced buckets. Each
+//  bucket is about 19% wider than the one before it, which bounds the
+//  relative error of quantiles. The zero value is an empty Histogram. A
+//  Histogram is not safe for concurrent use; those returned by a Dispatch
+//  are copies which it no longer updates.
+type Histogram struct {
+    counts [histBuckets]int64
+    count  int64
+    sum    time.Duration
+    max    time.Duration
+}
+
+//  The upper bound of bucket i. The last bucket has no upper bound.
+func histBound(i int) time.Duration {
+    if i >= histBuckets-1 {
+        return time.Duration(math.MaxInt64)
+    }
+    return time.Duration(float64(histMin) * math.Pow(2, float64(i)/histSteps))
+}
+
+//  The index of the bucket holding d.
+func histBucket(d time.Duration) int {
+    if d <= histMin {
+        return 0
+    }
+    var i = int(math.Ceil(histSteps * math.Log2(float64(d)/float64(histMin))))
+    if i >= histBuckets {
+        return histBuckets - 1
+    }
+    // Guard against rounding at the bucket boundaries.
+    if i > 0 && d <= histBound(i-1) {
+        i--
+    }
+    return i
+}
+
+//  Record a duration.
+func (h *Histogram) Observe(d time.Duration) {
+    if d < 0 {
+        d = 0
+    }
+    h.counts[histBucket(d)]++
+    h.count++
+    h.sum += d
+    if d > h.max {
+        h.max = d
+    }
+}
+
+//  The number of durations recorded.
+func (h *Histogram) Count() int64 {
+    return h.count
+}
+
+//  The sum of the durations recorded.
+func (h *Histogram) Sum() time.Duration {
+    return h.sum
+}
+
+//  The largest duration recorded.
+func (h *Histogram) Max() time.Duration {
+    return h.max
+}
+
+//  Returns an upper estimate of the q-quantile (0 <= q <= 1) of the
+//  durations recorded. Quantile(0.99) is the 99th percentile. Returns
+//  zero for an empty Histogram.
+func (h *Histogram) Quantile(q float64) time.Duration {
+    if h.count == 0 {
+        return 0
+    }
+    var rank = int64(math.Ceil(q * float64(h.count)))
+    if rank < 1 {
+        rank = 1
+    }
+    var seen int64
+    for i, n := range h.counts {
+        seen += n
+        if seen >= rank {
+            if b := histBound(i); b < h.max {
+                return b
+            }
+            break
+        }
+    }
+    return h.max
+}
+
+//  Latency histograms for one type of task. Wait is the time from when a
+//  task is put in the queue (including when it is retried) to when it
+//  starts. Run is the time from when it starts until it returns or times
+//  out.
+type TaskLatency struct {
+    Wait Histogram
+    Run  Histogram
+}
+
+//  Record the queue wait of a task of type typ.
+func (gq *Dispatch) observeWait(typ string, d time.Duration) {
+    gq.latLock.Lock()
+    gq.taskLatency(typ).Wait.Observe(d)
+    gq.latLock.Unlock()
+}
+
+//  Record the running time of a task of type typ.
+func (gq *Dispatch) observeRun(typ string, d time.Duration) {
+    gq.latLock.Lock()
+    gq.taskLatency(typ).Run.Observe(d)
+    gq.latLock.Unlock()
+}
+
+//  The histograms for task type typ. The caller must hold gq.latLock.
+func (gq *Dispatch) taskLatency(typ string) *TaskLatency {
+    var tl = gq.latency[typ]
+    if tl == nil {
+        tl = new(TaskLatency)
+        gq.latency[typ] = tl
+    }
+    return tl
+}
+
+//  Returns copies of the latency histograms for each task type (the value
+//  of Task.Type()) the Dispatch has started.
+//      for typ, tl := range gq.Latency() {
+//          log.Printf("%s: p50=%v p99=%v max=%v", typ,
+//              tl.Run.Quantile(0.5), tl.Run.Quantile(0.99), tl.Run.Max())
+//      }
+func (gq *Dispatch) Latency() map[string]TaskLatency {
+    gq.latLock.Lock()
+    defer gq.latLock.Unlock()
+    var m = make(map[string]TaskLatency, len(gq.latency))
+    for typ, tl := range gq.latency {
+        m[typ] = *tl
+    }
+    return m
+}
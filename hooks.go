@@ -0,0 +1,152 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    hooks.go
+ *  Description: Callbacks for each step in the lifecycle of a task.
+ */
+
+package dispatch
+
+import (
+    "time"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  The lifecycle step a TaskEvent describes.
+type EventKind int
+
+const (
+    EventEnqueue EventKind = iota // The task was accepted into the queue.
+    EventStart                    // An attempt of the task started.
+    EventFinish                   // An attempt returned, panicked or timed out.
+    EventDrop                     // The task was removed or discarded unrun.
+)
+
+var eventKindNames = []string{"enqueue", "start", "finish", "drop"}
+
+func (k EventKind) String() string {
+    if k < 0 || int(k) >= len(eventKindNames) {
+        return "unknown"
+    }
+    return eventKindNames[k]
+}
+
+//  A TaskEvent describes a task at one step of its lifecycle. Times which
+//  don't apply yet are zero.
+type TaskEvent struct {
+    Kind     EventKind
+    Task     queues.RegisteredTask
+    Attempt  int       // The attempt number, zero until the task starts.
+    Slot     int       // The concurrency slot of the latest attempt.
+    Enqueued time.Time // When the task was accepted.
+    Queued   time.Time // When the task was last put in the queue.
+    Started  time.Time // When the latest attempt started.
+    Finished time.Time // When the attempt finished, or the task was dropped.
+
+    // The error of a finished attempt, or the reason a task was dropped.
+    // Panics are reported as a *PanicError and timeouts as ErrTimeout.
+    Err error
+}
+
+//  The time the task spent in the queue before its latest attempt started.
+func (e TaskEvent) WaitTime() time.Duration {
+    if e.Started.IsZero() {
+        return 0
+    }
+    return e.Started.Sub(e.Queued)
+}
+
+//  The time the latest attempt spent running, for EventFinish.
+func (e TaskEvent) RunTime() time.Duration {
+    if e.Started.IsZero() || e.Finished.IsZero() {
+        return 0
+    }
+    return e.Finished.Sub(e.Started)
+}
+
+//  Hooks are functions called at each step of a task's lifecycle. Nil
+//  functions are skipped. Hooks are called from the goroutines of the
+//  Dispatch and its tasks, without any Dispatch locks held, so they must
+//  be safe to call concurrently. They should also return quickly, as they
+//  delay the tasks and the Dispatch that call them.
+type Hooks struct {
+    OnEnqueue func(TaskEvent)
+    OnStart   func(TaskEvent)
+    OnFinish  func(TaskEvent)
+    OnDrop    func(TaskEvent)
+}
+
+//  Attach a set of hooks to the Dispatch. Hooks are typically added right
+//  after the Dispatch is created, but it is safe to add them at any time.
+//      gq := dispatch.New(10)
+//      gq.AddHooks(dispatch.Hooks{
+//          OnFinish: func(e dispatch.TaskEvent) {
+//              log.Printf("task %d finished in %v", e.Task.Id(), e.RunTime())
+//          },
+//      })
+func (gq *Dispatch) AddHooks(h Hooks) {
+    gq.hLock.Lock()
+    defer gq.hLock.Unlock()
+    // Copy on write, so emit() can use the slice without holding the lock.
+    var hooks = make([]Hooks, len(gq.hooks), len(gq.hooks)+1)
+    copy(hooks, gq.hooks)
+    gq.hooks = append(hooks, h)
+}
+
+//  Call the hooks for the lifecycle step kind of task w, and log it.
+func (gq *Dispatch) emit(kind EventKind, w *dispatchTaskWrapper, finished time.Time, err error) {
+    gq.fire(w, gq.event(kind, w, finished, err))
+}
+
+//  Snapshot the state of task w for an event. Once w is in the queue the
+//  caller must hold gq.qLock, because the dispatcher may start w at any time.
+func (gq *Dispatch) event(kind EventKind, w *dispatchTaskWrapper, finished time.Time, err error) TaskEvent {
+    var e = TaskEvent{
+        Kind:     kind,
+        Task:     w,
+        Attempt:  w.attempt,
+        Enqueued: w.enqueued,
+        Queued:   w.queued,
+        Finished: finished,
+        Err:      err,
+    }
+    if w.attempt > 0 {
+        e.Started = w.started
+        e.Slot = w.slot
+    }
+    return e
+}
+
+//  Call the hooks for event e of task w, and log it.
+func (gq *Dispatch) fire(w *dispatchTaskWrapper, e TaskEvent) {
+    gq.hLock.Lock()
+    var hooks = gq.hooks
+    gq.hLock.Unlock()
+    if len(hooks) == 0 && gq.Logger == nil {
+        return
+    }
+    gq.logEvent(w, e)
+    for _, h := range hooks {
+        var f func(TaskEvent)
+        switch e.Kind {
+        case EventEnqueue:
+            f = h.OnEnqueue
+        case EventStart:
+            f = h.OnStart
+        case EventFinish:
+            f = h.OnFinish
+        case EventDrop:
+            f = h.OnDrop
+        }
+        if f != nil {
+            f(e)
+        }
+    }
+}
+
+//  Drop the task w, which will never run again, for the reason err.
+func (gq *Dispatch) drop(w *dispatchTaskWrapper, err error) {
+    gq.emit(EventDrop, w, time.Now(), err)
+    gq.done(w, nil, err)
+}
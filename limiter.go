@@ -0,0 +1,171 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    limiter.go
+ *  Description: Adaptive concurrency limits.
+ */
+
+package dispatch
+
+import (
+    "math"
+    "time"
+)
+
+//  A LimitSample describes a finished task attempt to a Limiter.
+type LimitSample struct {
+    RunTime time.Duration
+    Failed  bool // The attempt returned an error, panicked or timed out.
+    Running int  // Slots held when the attempt finished, including its own.
+    Limit   int  // The current concurrency limit.
+}
+
+//  A Limiter chooses the concurrency limit of a Dispatch from the results
+//  of its tasks. Observe is called with the Dispatch locked, so it need not
+//  be safe for concurrent use, but it must be quick and must not call
+//  methods of the Dispatch. A Limiter must not be shared by Dispatches.
+type Limiter interface {
+    // Return the new limit after an attempt finishes.
+    Observe(s LimitSample) int
+}
+
+//  Clamp a limit n to [min, max]. A non-positive max means no maximum, and
+//  the limit is never less than one.
+func clampLimit(n, min, max int) int {
+    if max > 0 && n > max {
+        n = max
+    }
+    if n < min {
+        n = min
+    }
+    if n < 1 {
+        n = 1
+    }
+    return n
+}
+
+//  An AIMDLimiter raises the limit by one for each successful attempt
+//  while the Dispatch is using at least half of it, and multiplies the
+//  limit by Backoff when an attempt fails or runs longer than Timeout.
+type AIMDLimiter struct {
+    Min, Max int           // Bounds on the limit. A zero Max is unbounded.
+    Backoff  float64       // In (0, 1). Zero means 0.9.
+    Timeout  time.Duration // Attempts running longer count as failures, if positive.
+}
+
+//  Observe an attempt for the Limiter interface.
+func (l *AIMDLimiter) Observe(s LimitSample) int {
+    var limit = s.Limit
+    switch {
+    case s.Failed || l.Timeout > 0 && s.RunTime > l.Timeout:
+        var backoff = l.Backoff
+        if backoff <= 0 || backoff >= 1 {
+            backoff = 0.9
+        }
+        limit = int(float64(limit) * backoff)
+    case 2*s.Running >= limit:
+        limit++
+    }
+    return clampLimit(limit, l.Min, l.Max)
+}
+
+//  A GradientLimiter compares the recent running time of tasks with their
+//  long term average, in the manner of TCP Vegas. While tasks run about as
+//  fast as usual the limit grows by about its square root; as they slow
+//  down, which suggests the tasks are contending for something, the limit
+//  shrinks in proportion. Failed attempts multiply the limit by Backoff.
+type GradientLimiter struct {
+    Min, Max  int     // Bounds on the limit. A zero Max is unbounded.
+    Backoff   float64 // In (0, 1). Zero means 0.9.
+    Tolerance float64 // How much slower tasks may get before the limit shrinks. Zero means 1.5.
+    Smoothing float64 // In (0, 1], how fast the limit moves. Zero means 0.2.
+
+    short, long float64 // Moving averages of the running time, in seconds.
+    limit       float64 // The unrounded limit.
+    samples     int
+}
+
+//  The number of samples averaged by the short and long moving averages.
+const (
+    gradientShort = 10
+    gradientLong  = 600
+)
+
+//  Observe an attempt for the Limiter interface.
+func (l *GradientLimiter) Observe(s LimitSample) int {
+    if l.limit == 0 || int(math.Floor(l.limit)) != s.Limit {
+        // The limit was changed with SetMaxGo, or this is the first sample.
+        l.limit = float64(s.Limit)
+    }
+    if s.Failed {
+        var backoff = l.Backoff
+        if backoff <= 0 || backoff >= 1 {
+            backoff = 0.9
+        }
+        l.limit = float64(clampLimit(int(l.limit*backoff), l.Min, l.Max))
+        return int(l.limit)
+    }
+
+    var rtt = s.RunTime.Seconds()
+    l.samples++
+    if l.samples == 1 {
+        l.short, l.long = rtt, rtt
+    } else {
+        l.short += (rtt - l.short) / gradientShort
+        l.long += (rtt - l.long) / float64(gradientLong)
+    }
+    // Let the long average decay quickly when tasks get faster, so the
+    // limit isn't held down by old slow samples.
+    if l.long > 2*l.short {
+        l.long = 2 * l.short
+    }
+
+    // The limit only grows while it is being used.
+    if 2*s.Running < s.Limit {
+        return s.Limit
+    }
+
+    var tolerance = l.Tolerance
+    if tolerance <= 0 {
+        tolerance = 1.5
+    }
+    var smoothing = l.Smoothing
+    if smoothing <= 0 || smoothing > 1 {
+        smoothing = 0.2
+    }
+    var gradient = 1.0
+    if l.short > 0 {
+        gradient = math.Max(0.5, math.Min(1, tolerance*l.long/l.short))
+    }
+    var target = l.limit*gradient + math.Sqrt(l.limit)
+    l.limit = (1-smoothing)*l.limit + smoothing*target
+    if n := clampLimit(int(l.limit), l.Min, l.Max); n != int(l.limit) {
+        l.limit = float64(n)
+    }
+    return int(l.limit)
+}
+
+//  Feed a finished attempt of task w to gq.Limiter, if there is one, and
+//  apply the limit it chooses.
+func (gq *Dispatch) adapt(w *dispatchTaskWrapper, finished time.Time, err error) {
+    if gq.Limiter == nil {
+        return
+    }
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    var n = gq.Limiter.Observe(LimitSample{
+        RunTime: finished.Sub(w.started),
+        Failed:  err != nil,
+        Running: gq.processing + w.weight,
+        Limit:   gq.maxgo,
+    })
+    if n < 1 {
+        n = 1
+    }
+    gq.maxgo = n
+    if gq.waitingToRun && gq.processing < n {
+        gq.waitingToRun = false
+        gq.nextWait.Done()
+    }
+}
@@ -0,0 +1,114 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    log.go
+ *  Description: Structured logging of task lifecycles with log/slog.
+ */
+
+package dispatch
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+)
+
+//  The levels at which a Dispatch logs each kind of record.
+type LogLevels struct {
+    Enqueue slog.Level // A task was accepted.
+    Start   slog.Level // A task attempt started.
+    Finish  slog.Level // A task attempt returned without error.
+    Failure slog.Level // A task attempt returned an error or timed out.
+    Panic   slog.Level // A task attempt panicked.
+    Drop    slog.Level // A task was removed or discarded without running.
+    Limit   slog.Level // The concurrency limit was reached.
+    Empty   slog.Level // The queue became empty.
+}
+
+//  The levels used when a Dispatch's LogLevels is nil.
+var DefaultLogLevels = LogLevels{
+    Enqueue: slog.LevelDebug,
+    Start:   slog.LevelDebug,
+    Finish:  slog.LevelDebug,
+    Failure: slog.LevelWarn,
+    Panic:   slog.LevelError,
+    Drop:    slog.LevelInfo,
+    Limit:   slog.LevelDebug,
+    Empty:   slog.LevelDebug,
+}
+
+//  The log levels in effect for the Dispatch.
+func (gq *Dispatch) logLevels() *LogLevels {
+    if gq.LogLevels != nil {
+        return gq.LogLevels
+    }
+    return &DefaultLogLevels
+}
+
+//  Write a record to gq.Logger, if it is set and the level is enabled.
+func (gq *Dispatch) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+    if gq.Logger == nil || !gq.Logger.Enabled(ctx, level) {
+        return
+    }
+    attrs = append(attrs, slog.String("dispatch", gq.Name))
+    gq.Logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+//  Log a task lifecycle event. The task's context is given to the logger,
+//  so handlers may take request-scoped values from it.
+func (gq *Dispatch) logEvent(w *dispatchTaskWrapper, e TaskEvent) {
+    if gq.Logger == nil {
+        return
+    }
+    var (
+        levels = gq.logLevels()
+        level  slog.Level
+        msg    string
+        attrs  = []slog.Attr{
+            slog.Int64("task_id", w.id),
+            slog.String("task_type", w.t.Type()),
+        }
+    )
+    switch e.Kind {
+    case EventEnqueue:
+        level, msg = levels.Enqueue, "task enqueued"
+    case EventStart:
+        level, msg = levels.Start, "task started"
+        attrs = append(attrs,
+            slog.Int("attempt", e.Attempt),
+            slog.Duration("wait", e.WaitTime()))
+    case EventFinish:
+        level, msg = levels.Finish, "task finished"
+        attrs = append(attrs,
+            slog.Int("attempt", e.Attempt),
+            slog.Duration("wait", e.WaitTime()),
+            slog.Duration("run", e.RunTime()))
+        var pe *PanicError
+        if errors.As(e.Err, &pe) {
+            level, msg = levels.Panic, "task panicked"
+            attrs = append(attrs,
+                slog.Any("panic", pe.Value),
+                slog.String("stack", string(pe.Stack)))
+        } else if e.Err != nil {
+            level, msg = levels.Failure, "task failed"
+            attrs = append(attrs, slog.Any("error", e.Err))
+        }
+    case EventDrop:
+        level, msg = levels.Drop, "task dropped"
+        attrs = append(attrs, slog.Any("error", e.Err))
+    }
+    gq.log(w.ctx, level, msg, attrs...)
+}
+
+//  Log that the concurrency limit was reached.
+func (gq *Dispatch) logLimit(running, maxgo int) {
+    gq.log(context.Background(), gq.logLevels().Limit, "concurrency limit reached",
+        slog.Int("running", running),
+        slog.Int("max_go", maxgo))
+}
+
+//  Log that the queue became empty.
+func (gq *Dispatch) logEmpty() {
+    gq.log(context.Background(), gq.logLevels().Empty, "queue empty")
+}
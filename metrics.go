@@ -0,0 +1,168 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    metrics.go
+ *  Description: OpenMetrics text exposition of Dispatch statistics.
+ */
+
+package dispatch
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+//  The Content-Type of the text written by WriteMetrics.
+const MetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+//  The statistics of one Dispatch, gathered before any text is written so
+//  that each metric family can list every Dispatch contiguously.
+type metricsSnapshot struct {
+    name    string
+    stats   Stats
+    types   []string
+    latency map[string]TaskLatency
+}
+
+//  A metric family drawn from Stats.
+type statsMetric struct {
+    name, typ, help string
+    value           func(Stats) float64
+}
+
+var statsMetrics = []statsMetric{
+    {"dispatch_queue_length", "gauge", "Tasks waiting in the queue.",
+        func(s Stats) float64 { return float64(s.Queued) }},
+    {"dispatch_running", "gauge", "Concurrency slots held by running tasks.",
+        func(s Stats) float64 { return float64(s.Running) }},
+    {"dispatch_stuck", "gauge", "Tasks past their deadline which have not returned.",
+        func(s Stats) float64 { return float64(s.Stuck) }},
+    {"dispatch_max_go", "gauge", "The limit on concurrently running tasks.",
+        func(s Stats) float64 { return float64(s.MaxGo) }},
+    {"dispatch_rate_limit", "gauge", "The limit on task starts per second, zero if unlimited.",
+        func(s Stats) float64 { return s.Rate }},
+    {"dispatch_enqueued", "counter", "Tasks accepted into the queue.",
+        func(s Stats) float64 { return float64(s.Enqueued) }},
+    {"dispatch_started", "counter", "Task attempts started.",
+        func(s Stats) float64 { return float64(s.Started) }},
+    {"dispatch_completed", "counter", "Task attempts which returned without error.",
+        func(s Stats) float64 { return float64(s.Completed) }},
+    {"dispatch_failed", "counter", "Task attempts which failed, panicked or timed out.",
+        func(s Stats) float64 { return float64(s.Failed) }},
+    {"dispatch_panicked", "counter", "Task attempts which panicked.",
+        func(s Stats) float64 { return float64(s.Panicked) }},
+    {"dispatch_timed_out", "counter", "Task attempts which ran past their deadline.",
+        func(s Stats) float64 { return float64(s.TimedOut) }},
+    {"dispatch_blocked_seconds", "counter", "Time spent waiting at the concurrency limit.",
+        func(s Stats) float64 { return s.BlockedTime.Seconds() }},
+    {"dispatch_idle_seconds", "counter", "Time spent waiting on an empty queue.",
+        func(s Stats) float64 { return s.IdleTime.Seconds() }},
+    {"dispatch_throttled_seconds", "counter", "Time spent waiting for the start rate limit.",
+        func(s Stats) float64 { return s.ThrottledTime.Seconds() }},
+}
+
+//  Write the metrics of each Dispatch to w in the OpenMetrics text format.
+//  Each sample is labelled with the Dispatch's Name, and latency
+//  histograms are also labelled with the task type. A Dispatch without a
+//  Name is labelled "dispatch N", N being its position in ds counting
+//  from 1. Nothing is written, and an error is returned, if two
+//  Dispatches would have the same label.
+func WriteMetrics(w io.Writer, ds ...*Dispatch) error {
+    var snaps = make([]metricsSnapshot, len(ds))
+    var names = make(map[string]bool, len(ds))
+    for i, d := range ds {
+        var name = d.Name
+        if name == "" {
+            name = fmt.Sprintf("dispatch %d", i+1)
+        }
+        if names[name] {
+            return fmt.Errorf("dispatch: more than one Dispatch has metrics name %q", name)
+        }
+        names[name] = true
+        snaps[i] = metricsSnapshot{name: name, stats: d.Stats(), latency: d.Latency()}
+        for typ := range snaps[i].latency {
+            snaps[i].types = append(snaps[i].types, typ)
+        }
+        sort.Strings(snaps[i].types)
+    }
+
+    var buf = new(bytes.Buffer)
+    for _, m := range statsMetrics {
+        fmt.Fprintf(buf, "# TYPE %s %s\n# HELP %s %s\n", m.name, m.typ, m.name, m.help)
+        var sample = m.name
+        if m.typ == "counter" {
+            sample += "_total"
+        }
+        for _, snap := range snaps {
+            fmt.Fprintf(buf, "%s{dispatch=%s} %s\n",
+                sample, metricsLabel(snap.name), metricsFloat(m.value(snap.stats)))
+        }
+    }
+    writeHistograms(buf, snaps, "dispatch_task_wait_seconds",
+        "Time tasks spent in the queue before starting.",
+        func(tl TaskLatency) *Histogram { return &tl.Wait })
+    writeHistograms(buf, snaps, "dispatch_task_run_seconds",
+        "Time tasks spent running.",
+        func(tl TaskLatency) *Histogram { return &tl.Run })
+    buf.WriteString("# EOF\n")
+
+    var _, err = w.Write(buf.Bytes())
+    return err
+}
+
+//  Write a histogram metric family, one histogram per Dispatch and task
+//  type. Only the bucket bounds which are powers of two are written.
+func writeHistograms(buf *bytes.Buffer, snaps []metricsSnapshot, name, help string, hist func(TaskLatency) *Histogram) {
+    fmt.Fprintf(buf, "# TYPE %s histogram\n# HELP %s %s\n", name, name, help)
+    for _, snap := range snaps {
+        for _, typ := range snap.types {
+            var h = hist(snap.latency[typ])
+            var labels = fmt.Sprintf("dispatch=%s,task_type=%s",
+                metricsLabel(snap.name), metricsLabel(typ))
+            var cumulative int64
+            for i := 0 ; i < histBuckets-1 ; i++ {
+                cumulative += h.counts[i]
+                if i%histSteps != 0 {
+                    continue
+                }
+                fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n",
+                    name, labels, metricsFloat(histBound(i).Seconds()), cumulative)
+            }
+            fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+            fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.count)
+            fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, metricsFloat(h.sum.Seconds()))
+        }
+    }
+}
+
+//  Quote a label value, escaping backslashes, quotes and newlines.
+func metricsLabel(v string) string {
+    var r = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+    return `"` + r.Replace(v) + `"`
+}
+
+//  Format a sample value.
+func metricsFloat(v float64) string {
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//  Returns an http.Handler serving the metrics of each Dispatch, for
+//  scraping by Prometheus.
+//      http.Handle("/metrics", dispatch.MetricsHandler(gq))
+func MetricsHandler(ds ...*Dispatch) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var buf = new(bytes.Buffer)
+        if err := WriteMetrics(buf, ds...); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", MetricsContentType)
+        w.Write(buf.Bytes())
+    })
+}
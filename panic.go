@@ -0,0 +1,60 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    panic.go
+ *  Description: Recovery of panics raised by dispatched tasks.
+ */
+
+package dispatch
+
+import (
+    "context"
+    "fmt"
+    "runtime/debug"
+)
+
+//  A PanicPolicy determines what a Dispatch does when a task panics.
+type PanicPolicy int
+
+const (
+    //  Recover the panic, free the task's slot, and report a *PanicError
+    //  through the task's Future and the Dispatch's PanicHandler. This is
+    //  the default.
+    PanicRecover PanicPolicy = iota
+    //  Let the panic crash the process, as an unrecovered panic in any
+    //  goroutine does.
+    PanicCrash
+)
+
+//  A PanicError records a panic recovered from a task.
+type PanicError struct {
+    Id    int64       // The task's dispatch id.
+    Type  string      // The task's Type().
+    Value interface{} // The value passed to panic().
+    Stack []byte      // The stack trace of the panicking goroutine.
+}
+
+func (pe *PanicError) Error() string {
+    return fmt.Sprintf("dispatch: task %d (%s) panicked: %v", pe.Id, pe.Type, pe.Value)
+}
+
+//  Call run(ctx, id) for the task w, handling any panic according to
+//  gq.PanicPolicy.
+func (gq *Dispatch) call(w *dispatchTaskWrapper, ctx context.Context, run func(context.Context, int64) (interface{}, error)) (value interface{}, err error) {
+    if gq.PanicPolicy == PanicCrash {
+        return run(ctx, w.id)
+    }
+    defer func() {
+        var r = recover()
+        if r == nil {
+            return
+        }
+        var pe = &PanicError{w.id, w.t.Type(), r, debug.Stack()}
+        value, err = nil, pe
+        if gq.PanicHandler != nil {
+            gq.PanicHandler(pe)
+        }
+    }()
+    return run(ctx, w.id)
+}
@@ -102,14 +102,17 @@ func (h *pQueue) Push(x interface{}) {
     }
 }
 
-//  Remove the first PrioritizedTask from the array and return it.
+//  Remove the last PrioritizedTask from the array and return it. The heap
+//  package swaps the head into the last position before calling Pop.
 func (h *pQueue) Pop() interface{} {
-    if len(h.elements) <= 0 {
+    var n = len(h.elements)
+    if n <= 0 {
         panic("empty")
     }
-    var head = h.elements[0]
-    h.elements = h.elements[1:]
-    return head
+    var last = h.elements[n-1]
+    h.elements[n-1] = nil
+    h.elements = h.elements[:n-1]
+    return last
 }
 
 //  Find a Task with a given id in the queue. Return it along with its
@@ -176,6 +179,55 @@ func (pq *PriorityQueue) SetKey(id int64, k float64) {
     heap.Push(pq.h, task)
 }
 
+//  Remove the task with a given id with runtime O(n). Returns nil if no
+//  such task is in the queue.
+func (pq *PriorityQueue) Remove(id int64) RegisteredTask {
+    var i, _ = pq.h.FindId(id)
+    if i < 0 {
+        return nil
+    }
+    return heap.Remove(pq.h, i).(RegisteredTask)
+}
+
+//  Remove all tasks for which f returns true with runtime O(n log(n)).
+func (pq *PriorityQueue) RemoveIf(f func(RegisteredTask) bool) []RegisteredTask {
+    var (
+        kept    = pq.h.elements[:0]
+        removed []RegisteredTask
+    )
+    for _, task := range pq.h.elements {
+        if f(task) {
+            removed = append(removed, task)
+        } else {
+            kept = append(kept, task)
+        }
+    }
+    for i := len(kept) ; i < len(pq.h.elements) ; i++ {
+        pq.h.elements[i] = nil
+    }
+    pq.h.elements = kept
+    heap.Init(pq.h)
+    sort.Sort(&pQueue{removed})
+    return removed
+}
+
+//  Call f on each task in order of increasing key, until f returns false.
+//  The head is visited in O(1) time, but visiting the rest sorts a copy of
+//  the heap, with runtime O(n log(n)).
+func (pq *PriorityQueue) Do(f func(RegisteredTask) bool) {
+    if pq.Len() == 0 || !f(pq.h.elements[0]) {
+        return
+    }
+    var sorted = &pQueue{make([]RegisteredTask, len(pq.h.elements)-1)}
+    copy(sorted.elements, pq.h.elements[1:])
+    sort.Sort(sorted)
+    for _, task := range sorted.elements {
+        if !f(task) {
+            return
+        }
+    }
+}
+
 //  A priority queue based on the "container/vector" package. This priority
 //  queue implementation has fast dequeues and slow enqueues. 
 type VectorPriorityQueue struct {
@@ -249,6 +301,47 @@ func (vpq *VectorPriorityQueue) SetKey(id int64, k float64) {
     }
 }
 
+//  Remove the task with a given id in O(n) time. Returns nil if no such
+//  task is in the queue.
+func (vpq *VectorPriorityQueue) Remove(id int64) RegisteredTask {
+    for i := vpq.head ; i < vpq.v.Len() ; i++ {
+        var task = vpq.v.At(i).(RegisteredTask)
+        if task.Id() == id {
+            vpq.v.Delete(i)
+            return task
+        }
+    }
+    return nil
+}
+
+//  Remove all tasks for which f returns true in O(n) time.
+func (vpq *VectorPriorityQueue) RemoveIf(f func(RegisteredTask) bool) []RegisteredTask {
+    var (
+        n       = vpq.v.Len()
+        kept    = vpq.head
+        removed []RegisteredTask
+    )
+    for i := vpq.head ; i < n ; i++ {
+        var task = vpq.v.At(i).(RegisteredTask)
+        if f(task) {
+            removed = append(removed, task)
+            continue
+        }
+        vpq.v.Set(kept, task)
+        kept++
+    }
+    vpq.v.Cut(kept, n)
+    return removed
+}
+
+//  Call f on each task in order of increasing key, until f returns false.
+func (vpq *VectorPriorityQueue) Do(f func(RegisteredTask) bool) {
+    for i := vpq.head ; i < vpq.v.Len() ; i++ {
+        if !f(vpq.v.At(i).(RegisteredTask)) {
+            return
+        }
+    }
+}
 
 //  An array-based priority queue with a constant time dequeue and a
 //  linear time equeue. It should slightly outperform a
@@ -322,3 +415,46 @@ func (apq *ArrayPriorityQueue) Dequeue() RegisteredTask {
 //  Add a task to the queue with runtime O(n).
 func (apq *ArrayPriorityQueue) SetKey(id int64, k float64) {
 }
+
+//  Remove the task with a given id with runtime O(n). Returns nil if no
+//  such task is in the queue.
+func (apq *ArrayPriorityQueue) Remove(id int64) RegisteredTask {
+    var removed = apq.RemoveIf(func(task RegisteredTask) bool {
+        return task.Id() == id
+    })
+    if len(removed) == 0 {
+        return nil
+    }
+    return removed[0]
+}
+
+//  Remove all tasks for which f returns true with runtime O(n).
+func (apq *ArrayPriorityQueue) RemoveIf(f func(RegisteredTask) bool) []RegisteredTask {
+    var (
+        kept    = apq.head
+        removed []RegisteredTask
+    )
+    for i := apq.head ; i < apq.tail ; i++ {
+        var task = apq.v[i]
+        if f(task) {
+            removed = append(removed, task)
+            continue
+        }
+        apq.v[kept] = task
+        kept++
+    }
+    for i := kept ; i < apq.tail ; i++ {
+        apq.v[i] = nil
+    }
+    apq.tail = kept
+    return removed
+}
+
+//  Call f on each task in order of increasing key, until f returns false.
+func (apq *ArrayPriorityQueue) Do(f func(RegisteredTask) bool) {
+    for i := apq.head ; i < apq.tail ; i++ {
+        if !f(apq.v[i]) {
+            return
+        }
+    }
+}
@@ -10,5 +10,34 @@ import (
     "testing"
 )
 
-func TestDummy(T *testing.T) {
+func TestPriorityQueueRemove(T *testing.T) {
+    testRemove(T, NewPriorityQueue(), testTasks(12), []int64{1, 5, 7, 9, 11})
+}
+
+func TestVectorPriorityQueueRemove(T *testing.T) {
+    testRemove(T, NewVectorPriorityQueue(), testTasks(12), []int64{1, 5, 7, 9, 11})
+}
+
+func TestArrayPriorityQueueRemove(T *testing.T) {
+    testRemove(T, NewArrayPriorityQueue(), testTasks(12), []int64{1, 5, 7, 9, 11})
+}
+
+//  Reverse tasks so that priority queues must reorder them.
+func reversed(tasks []RegisteredTask) []RegisteredTask {
+    for i, j := 0, len(tasks)-1 ; i < j ; i, j = i+1, j-1 {
+        tasks[i], tasks[j] = tasks[j], tasks[i]
+    }
+    return tasks
+}
+
+func TestPriorityQueueDo(T *testing.T) {
+    testDo(T, NewPriorityQueue(), reversed(testTasks(9)), []int64{1, 2, 3, 4, 5, 6, 7, 8, 9})
+}
+
+func TestVectorPriorityQueueDo(T *testing.T) {
+    testDo(T, NewVectorPriorityQueue(), reversed(testTasks(9)), []int64{1, 2, 3, 4, 5, 6, 7, 8, 9})
+}
+
+func TestArrayPriorityQueueDo(T *testing.T) {
+    testDo(T, NewArrayPriorityQueue(), reversed(testTasks(9)), []int64{1, 2, 3, 4, 5, 6, 7, 8, 9})
 }
@@ -19,7 +19,7 @@ import ()
 type Task interface {
     SetFunc(func(id int64))
     Func() func(id int64)
-    Type() string // Used mostly for debugging
+    Type() string // Used for debugging and per-type statistics
 }
 //  A Task given to a Dispatch is given a unique id and becomes a
 //  RegisteredTask.
@@ -43,6 +43,15 @@ type Queue interface {
     Dequeue() RegisteredTask     // Remove the next task.
     Len() int                    // Number of items waiting for processing.
     SetKey(int64, float64)       // Set a task's key (priority queues).
+    Remove(int64) RegisteredTask // Remove a task by id (nil if not found).
+
+    // Remove every task for which the function returns true. The removed
+    // tasks are returned in the order they would have been dequeued.
+    RemoveIf(func(RegisteredTask) bool) []RegisteredTask
+
+    // Call the function on each task in the order they would be dequeued,
+    // without modifying the queue, until it returns false.
+    Do(func(RegisteredTask) bool)
 }
 
 //  A First In First Out (FIFO) Queue implemented as a circular slice.
@@ -100,6 +109,54 @@ func (dq *FIFO) Dequeue() RegisteredTask {
 //  Does nothing. See Queue.
 func (dq *FIFO) SetKey(id int64, k float64) {}
 
+//  Remove the task with a given id in O(n) time. Returns nil if no such
+//  task is in the FIFO.
+func (dq *FIFO) Remove(id int64) RegisteredTask {
+    var removed = dq.RemoveIf(func(task RegisteredTask) bool {
+        return task.Id() == id
+    })
+    if len(removed) == 0 {
+        return nil
+    }
+    return removed[0]
+}
+
+//  Remove all tasks for which f returns true in O(n) time. The remaining
+//  tasks keep their order.
+func (dq *FIFO) RemoveIf(f func(RegisteredTask) bool) []RegisteredTask {
+    var (
+        n       = len(dq.circ)
+        kept    = 0
+        removed []RegisteredTask
+        zero    RegisteredTask
+    )
+    for i := 0 ; i < dq.length ; i++ {
+        var task = dq.circ[(dq.head+i)%n]
+        if f(task) {
+            removed = append(removed, task)
+            continue
+        }
+        dq.circ[(dq.head+kept)%n] = task
+        kept++
+    }
+    for i := kept ; i < dq.length ; i++ {
+        dq.circ[(dq.head+i)%n] = zero
+    }
+    dq.length = kept
+    dq.tail = (dq.head + kept) % n
+    return removed
+}
+
+//  Call f on each task, from head to tail, until f returns false.
+func (dq *FIFO) Do(f func(RegisteredTask) bool) {
+    var n = len(dq.circ)
+    for i := 0 ; i < dq.length ; i++ {
+        if !f(dq.circ[(dq.head+i)%n]) {
+            return
+        }
+    }
+}
+
 //  A Last In First Out (LIFO) Queue (also known as a stack) implemented
 //  with a slice.
 type LIFO struct {
@@ -146,3 +203,53 @@ func (dq *LIFO) Dequeue() RegisteredTask {
 
 //  Does nothing. See Queue.
 func (dq *LIFO) SetKey(id int64, k float64) {}
+
+//  Remove the task with a given id in O(n) time. Returns nil if no such
+//  task is in the LIFO.
+func (dq *LIFO) Remove(id int64) RegisteredTask {
+    var removed = dq.RemoveIf(func(task RegisteredTask) bool {
+        return task.Id() == id
+    })
+    if len(removed) == 0 {
+        return nil
+    }
+    return removed[0]
+}
+
+//  Remove all tasks for which f returns true in O(n) time. The remaining
+//  tasks keep their order.
+func (dq *LIFO) RemoveIf(f func(RegisteredTask) bool) []RegisteredTask {
+    var (
+        kept    = 0
+        removed []RegisteredTask
+        zero    RegisteredTask
+    )
+    for i := 0 ; i < dq.top ; i++ {
+        var task = dq.stack[i]
+        if f(task) {
+            removed = append(removed, task)
+            continue
+        }
+        dq.stack[kept] = task
+        kept++
+    }
+    for i := kept ; i < dq.top ; i++ {
+        dq.stack[i] = zero
+    }
+    // Tasks are popped from the top, so reverse into dequeue order.
+    for i, j := 0, len(removed)-1 ; i < j ; i, j = i+1, j-1 {
+        removed[i], removed[j] = removed[j], removed[i]
+    }
+    dq.top = kept
+    return removed
+}
+
+//  Call f on each task, from the top of the stack down, until f returns
+//  false.
+func (dq *LIFO) Do(f func(RegisteredTask) bool) {
+    for i := dq.top - 1 ; i >= 0 ; i-- {
+        if !f(dq.stack[i]) {
+            return
+        }
+    }
+}
@@ -12,3 +12,113 @@ import (
 
 func TestDummy(T *testing.T) {
 }
+
+//  A minimal RegisteredTask for testing Queue implementations.
+type testTask struct {
+    id int64
+    t  Task
+}
+
+func (tt testTask) Task() Task           { return tt.t }
+func (tt testTask) Func() func(id int64) { return tt.t.Func() }
+func (tt testTask) Id() int64            { return tt.id }
+
+//  Create n registered PTasks with ids 1 through n and keys equal to
+//  their ids.
+func testTasks(n int) []RegisteredTask {
+    var tasks = make([]RegisteredTask, n)
+    for i := range tasks {
+        var id = int64(i + 1)
+        tasks[i] = testTask{id, &PTask{func(int64) {}, float64(id)}}
+    }
+    return tasks
+}
+
+//  Enqueue tasks, remove some of them, and check that the queue yields
+//  the remaining ones in the order given by expect.
+func testRemove(T *testing.T, q Queue, tasks []RegisteredTask, expect []int64) {
+    for _, task := range tasks {
+        q.Enqueue(task)
+    }
+    if task := q.Remove(3); task == nil || task.Id() != 3 {
+        T.Fatalf("Remove(3) returned %v", task)
+    }
+    if task := q.Remove(3); task != nil {
+        T.Fatalf("Remove(3) twice returned %v", task)
+    }
+    var removed = q.RemoveIf(func(task RegisteredTask) bool {
+        return task.Id()%2 == 0
+    })
+    if len(removed) != len(tasks)/2 {
+        T.Fatalf("RemoveIf removed %d tasks", len(removed))
+    }
+    if n := q.Len(); n != len(expect) {
+        T.Fatalf("Len() = %d after removal, expected %d", n, len(expect))
+    }
+    for _, id := range expect {
+        if task := q.Dequeue(); task.Id() != id {
+            T.Fatalf("Dequeue() returned %d, expected %d", task.Id(), id)
+        }
+    }
+}
+
+//  Enqueue tasks and check that Do visits them in the order given by
+//  expect, stops early when asked, and leaves the queue unchanged.
+func testDo(T *testing.T, q Queue, tasks []RegisteredTask, expect []int64) {
+    for _, task := range tasks {
+        q.Enqueue(task)
+    }
+    var seen []int64
+    q.Do(func(task RegisteredTask) bool {
+        seen = append(seen, task.Id())
+        return true
+    })
+    if len(seen) != len(expect) {
+        T.Fatalf("Do visited %d tasks, expected %d", len(seen), len(expect))
+    }
+    for i, id := range expect {
+        if seen[i] != id {
+            T.Fatalf("Do visited %v, expected %v", seen, expect)
+        }
+    }
+    var visited = 0
+    q.Do(func(task RegisteredTask) bool {
+        visited++
+        return visited < 2
+    })
+    if visited != 2 {
+        T.Fatalf("Do visited %d tasks after being stopped at 2", visited)
+    }
+    for _, id := range expect {
+        if task := q.Dequeue(); task.Id() != id {
+            T.Fatalf("Dequeue() returned %d after Do, expected %d", task.Id(), id)
+        }
+    }
+}
+
+func TestFIFORemove(T *testing.T) {
+    var q = NewFIFO()
+    // Wrap the circular slice around before removing.
+    for _, task := range testTasks(5) {
+        q.Enqueue(task)
+        q.Dequeue()
+    }
+    testRemove(T, q, testTasks(12), []int64{1, 5, 7, 9, 11})
+}
+
+func TestLIFORemove(T *testing.T) {
+    testRemove(T, NewLIFO(), testTasks(12), []int64{11, 9, 7, 5, 1})
+}
+
+func TestFIFODo(T *testing.T) {
+    var q = NewFIFO()
+    for _, task := range testTasks(7) {
+        q.Enqueue(task)
+        q.Dequeue()
+    }
+    testDo(T, q, testTasks(5), []int64{1, 2, 3, 4, 5})
+}
+
+func TestLIFODo(T *testing.T) {
+    testDo(T, NewLIFO(), testTasks(5), []int64{5, 4, 3, 2, 1})
+}
@@ -0,0 +1,132 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    ratelimit.go
+ *  Description: Limiting the rate at which a Dispatch starts tasks.
+ */
+
+package dispatch
+
+import (
+    "math"
+    "time"
+)
+
+//  A token bucket. A task may start when a token is available. Tokens
+//  accumulate at rate per second, up to burst of them. Protected by pLock.
+type tokenBucket struct {
+    rate    float64 // Non-positive when starts are not limited.
+    burst   int
+    tokens  float64
+    last    time.Time // When tokens was last brought up to date.
+    changed chan bool // Closed when the rate changes, if anyone waits.
+}
+
+//  Bring the tokens up to date at time now.
+func (tb *tokenBucket) refill(now time.Time) {
+    if !tb.last.IsZero() {
+        tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+    }
+    tb.tokens = math.Min(tb.tokens, float64(tb.burst))
+    tb.last = now
+}
+
+//  Take a token at time now. If none is available, return how long until
+//  one will be.
+func (tb *tokenBucket) take(now time.Time) (bool, time.Duration) {
+    tb.refill(now)
+    if tb.tokens >= 1 {
+        tb.tokens--
+        return true, 0
+    }
+    var wait = time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+    return false, wait + 1
+}
+
+//  Limit the rate at which gq starts tasks to rate per second, allowing
+//  bursts of up to burst tasks (at least one). A non-positive rate removes
+//  the limit. This takes effect immediately and may be called while the
+//  Dispatch is running. The rate limit applies alongside the concurrency
+//  limit, so a task starts only when both allow it.
+func (gq *Dispatch) SetRate(rate float64, burst int) {
+    if burst < 1 {
+        burst = 1
+    }
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    var now = time.Now()
+    if gq.bucket.rate > 0 {
+        gq.bucket.refill(now)
+    } else {
+        // Start with a full bucket.
+        gq.bucket.tokens = float64(burst)
+    }
+    gq.bucket.rate = rate
+    gq.bucket.burst = burst
+    gq.bucket.last = now
+    gq.bucket.tokens = math.Min(gq.bucket.tokens, float64(burst))
+    if gq.bucket.changed != nil {
+        close(gq.bucket.changed)
+        gq.bucket.changed = nil
+    }
+}
+
+//  Returns the limit on task starts per second, zero if there is none,
+//  and the burst size.
+func (gq *Dispatch) Rate() (float64, int) {
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    if gq.bucket.rate <= 0 {
+        return 0, 0
+    }
+    return gq.bucket.rate, gq.bucket.burst
+}
+
+//  Wait for the rate limit to allow a task to start. Returns false if kill
+//  is closed first.
+func (gq *Dispatch) throttle(kill chan bool) bool {
+    for {
+        gq.pLock.Lock()
+        var now = time.Now()
+        gq.throttled.stop(now)
+        if gq.bucket.rate <= 0 {
+            gq.pLock.Unlock()
+            return true
+        }
+        var ok, wait = gq.bucket.take(now)
+        if ok {
+            gq.pLock.Unlock()
+            return true
+        }
+        if gq.bucket.changed == nil {
+            gq.bucket.changed = make(chan bool)
+        }
+        var changed = gq.bucket.changed
+        gq.throttled.start(now)
+        gq.pLock.Unlock()
+
+        var timer = time.NewTimer(wait)
+        select {
+        case <-timer.C:
+        case <-changed:
+            timer.Stop()
+        case <-kill:
+            timer.Stop()
+            gq.pLock.Lock()
+            gq.throttled.stop(time.Now())
+            gq.pLock.Unlock()
+            return false
+        }
+    }
+}
+
+//  Give back a token taken by gq.throttle(kill) for a task which couldn't
+//  be started after all.
+func (gq *Dispatch) unthrottle() {
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    if gq.bucket.rate > 0 {
+        gq.bucket.tokens = math.Min(gq.bucket.tokens+1, float64(gq.bucket.burst))
+    }
+}
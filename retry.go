@@ -0,0 +1,165 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    retry.go
+ *  Description: Retrying failed tasks with exponential backoff.
+ */
+
+package dispatch
+
+import (
+    "context"
+    "math"
+    "math/rand"
+    "time"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  A RetryPolicy determines whether, and when, a failed task is run again.
+//  A task fails when it returns an error, panics (with PanicRecover) or
+//  times out. Retried tasks keep their id and go back through the
+//  Dispatch's queue, so priority queues order them as usual.
+//      gq.Retry = &dispatch.RetryPolicy{
+//          MaxAttempts: 5,
+//          Backoff:     100 * time.Millisecond,
+//          MaxBackoff:  10 * time.Second,
+//          Jitter:      0.2,
+//      }
+type RetryPolicy struct {
+    // The maximum number of times a task is started, including the first
+    // attempt. Values less than 2 disable retries.
+    MaxAttempts int
+
+    // The delay before the first retry. Each later delay is Multiplier
+    // times the one before it, up to MaxBackoff (if it's positive). The
+    // Multiplier is 2 when it is zero.
+    Backoff    time.Duration
+    MaxBackoff time.Duration
+    Multiplier float64
+
+    // The fraction (0 to 1) by which each delay is randomly varied.
+    Jitter float64
+
+    // Decides which errors are worth retrying. All errors are retried when
+    // Retryable is nil.
+    Retryable func(error) bool
+}
+
+//  A RetryTask is a queues.Task with its own RetryPolicy, which takes the
+//  place of the Dispatch's. A nil policy means the task is not retried.
+type RetryTask interface {
+    queues.Task
+    RetryPolicy() *RetryPolicy
+}
+
+//  Key for the attempt number stored in a task's context.
+type attemptKey struct{}
+
+//  Returns the attempt number (1 for the first) of the task running with
+//  context ctx. Returns 0 if ctx did not come from a Dispatch.
+func Attempt(ctx context.Context) int {
+    var n, _ = ctx.Value(attemptKey{}).(int)
+    return n
+}
+
+//  Returns true if a task which failed with err on a given attempt should
+//  be run again.
+func (rp *RetryPolicy) retry(attempt int, err error) bool {
+    if rp == nil || attempt >= rp.MaxAttempts {
+        return false
+    }
+    return rp.Retryable == nil || rp.Retryable(err)
+}
+
+//  The delay before retrying a task which failed on a given attempt.
+func (rp *RetryPolicy) delay(attempt int) time.Duration {
+    var mult = rp.Multiplier
+    if mult == 0 {
+        mult = 2
+    }
+    var d = float64(rp.Backoff) * math.Pow(mult, float64(attempt-1))
+    if rp.Jitter > 0 {
+        d += d * rp.Jitter * (2*rand.Float64() - 1)
+    }
+    if rp.MaxBackoff > 0 && d > float64(rp.MaxBackoff) {
+        d = float64(rp.MaxBackoff)
+    }
+    if d < 0 {
+        d = 0
+    }
+    return time.Duration(d)
+}
+
+//  Returns the RetryPolicy that applies to the task w.
+func (gq *Dispatch) retryPolicy(w *dispatchTaskWrapper) *RetryPolicy {
+    if rt, ok := w.t.(RetryTask); ok {
+        return rt.RetryPolicy()
+    }
+    return gq.Retry
+}
+
+//  A task waiting for its backoff to elapse before going back in the queue.
+type retryTimer struct {
+    w     *dispatchTaskWrapper
+    timer *time.Timer
+}
+
+//  Called once an execution of w has ended and its slot has been given
+//  back. A failed task is retried if its policy allows, otherwise it is
+//  sent to gq.DeadLetters and its Future is resolved.
+func (gq *Dispatch) complete(w *dispatchTaskWrapper, value interface{}, err error) {
+    var finished = time.Now()
+    gq.count(err)
+    gq.observeRun(w.t.Type(), finished.Sub(w.started))
+    gq.adapt(w, finished, err)
+    gq.emit(EventFinish, w, finished, err)
+    if err == nil || w.ctx.Err() != nil {
+        gq.resolve(w, value, err)
+        return
+    }
+    var rp = gq.retryPolicy(w)
+    if !rp.retry(w.attempt, err) {
+        gq.deadLetter(w, err)
+        gq.resolve(w, value, err)
+        return
+    }
+    var r = &retryTimer{w: w}
+    gq.qLock.Lock()
+    if gq.aborted {
+        gq.qLock.Unlock()
+        gq.deadLetter(w, err)
+        gq.resolve(w, value, err)
+        return
+    }
+    gq.retrying[w.id] = r
+    r.timer = time.AfterFunc(rp.delay(w.attempt), func() { gq.requeue(r) })
+    gq.qLock.Unlock()
+}
+
+//  Put a task back in the queue after its backoff. Nothing is done if the
+//  retry was cancelled in the meantime.
+func (gq *Dispatch) requeue(r *retryTimer) {
+    gq.qLock.Lock()
+    if gq.retrying[r.w.id] != r {
+        gq.qLock.Unlock()
+        return
+    }
+    delete(gq.retrying, r.w.id)
+    gq.push(r.w)
+    var notify = gq.resized()
+    gq.qLock.Unlock()
+    notify()
+}
+
+//  Cancel the pending retry of a task, returning it. Returns nil if the
+//  task is not waiting to be retried. The caller must hold gq.qLock.
+func (gq *Dispatch) cancelRetry(id int64) *dispatchTaskWrapper {
+    var r = gq.retrying[id]
+    if r == nil {
+        return nil
+    }
+    r.timer.Stop()
+    delete(gq.retrying, id)
+    return r.w
+}
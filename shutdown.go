@@ -0,0 +1,117 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    shutdown.go
+ *  Description: Graceful shutdown of a Dispatch.
+ */
+
+package dispatch
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  The error given for tasks enqueued after, or discarded by, Shutdown().
+var ErrClosed = errors.New("dispatch: shut down")
+
+//  The error returned by Shutdown() in Drain mode when the Dispatch isn't
+//  running, as nothing would ever run the queued tasks.
+var ErrNotStarted = errors.New("dispatch: not started")
+
+//  A ShutdownMode determines what Shutdown() does with queued tasks.
+type ShutdownMode int
+
+const (
+    //  Run every queued task and wait for all of them to finish.
+    Drain ShutdownMode = iota
+    //  Discard the queue and wait only for tasks that are already running.
+    Abort
+)
+
+//  A summary of the tasks handled during a call to Shutdown().
+type ShutdownReport struct {
+    Ran     []int64 // Tasks which finished successfully during the shutdown.
+    Failed  []int64 // Tasks which failed for good, or timed out, during the shutdown.
+    Dropped []int64 // Queued tasks that were discarded without running.
+    Running []int64 // Tasks still running when Shutdown() returned.
+}
+
+//  Stop accepting new tasks and wait for the Dispatch to finish its work.
+//  In Drain mode every queued task is run, so the Dispatch must be
+//  running; if it isn't, ErrNotStarted is returned and nothing is done. A
+//  paused Dispatch is resumed. In Abort mode queued tasks, and tasks
+//  waiting to be retried, are discarded immediately. Either way,
+//  Shutdown() waits for running tasks to finish before stopping the
+//  Dispatch. If ctx is done first, any tasks left in the queue are
+//  discarded, the Dispatch is stopped and ctx.Err() is returned. Tasks
+//  which are discarded have their Futures resolved with ErrClosed. A
+//  Dispatch published with expvar is unpublished.
+//      ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//      defer cancel()
+//      report, err := gq.Shutdown(ctx, dispatch.Drain)
+func (gq *Dispatch) Shutdown(ctx context.Context, mode ShutdownMode) (*ShutdownReport, error) {
+    gq.startLock.Lock()
+    var started = gq.started
+    gq.startLock.Unlock()
+    if mode == Drain && !started {
+        return nil, ErrNotStarted
+    }
+
+    var report = new(ShutdownReport)
+    gq.pLock.Lock()
+    gq.report = report
+    gq.pLock.Unlock()
+
+    gq.qLock.Lock()
+    gq.closed = true
+    if mode == Drain {
+        gq.resume()
+    }
+    var notify = gq.resized() // Wake producers waiting for space.
+    gq.qLock.Unlock()
+    notify()
+    if mode == Abort {
+        report.Dropped = gq.discard()
+    }
+
+    // Wait for in-flight tasks in the background so ctx can interrupt.
+    var finished = make(chan bool)
+    go func() {
+        gq.inflight.Wait()
+        close(finished)
+    }()
+    var err error
+    select {
+    case <-finished:
+    case <-ctx.Done():
+        err = ctx.Err()
+        report.Dropped = append(report.Dropped, gq.discard()...)
+    }
+    gq.Stop()
+    gq.Unpublish()
+
+    gq.pLock.Lock()
+    gq.report = nil
+    for id := range gq.running {
+        report.Running = append(report.Running, id)
+    }
+    gq.pLock.Unlock()
+    sort.Slice(report.Running, func(i, j int) bool {
+        return report.Running[i] < report.Running[j]
+    })
+    return report, err
+}
+
+//  Empty the queue and cancel pending retries, resolving each task's
+//  Future with ErrClosed. Tasks which fail from now on are not retried.
+//  The ids of the discarded tasks are returned.
+func (gq *Dispatch) discard() []int64 {
+    gq.qLock.Lock()
+    gq.aborted = true
+    gq.qLock.Unlock()
+    return gq.removeIf(func(queues.RegisteredTask) bool { return true }, ErrClosed)
+}
@@ -0,0 +1,77 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    snapshot.go
+ *  Description: Snapshots of the queued and running tasks of a Dispatch.
+ */
+
+package dispatch
+
+import (
+    "sort"
+    "time"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  A TaskInfo describes a task in a Dispatch at the time of a snapshot.
+type TaskInfo struct {
+    Id          int64
+    Type        string
+    Key         float64 // The priority key, if Prioritized.
+    Prioritized bool    // The task is a queues.PrioritizedTask.
+    Attempt     int     // Attempts started so far, including a running one.
+    Enqueued    time.Time
+    Queued      time.Time // When the task was last put in the queue.
+    Started     time.Time // When the latest attempt started, if any.
+}
+
+//  Describe task w. The caller must hold the lock of the structure w is in.
+func taskInfo(w *dispatchTaskWrapper) TaskInfo {
+    var info = TaskInfo{
+        Id:       w.id,
+        Type:     w.t.Type(),
+        Attempt:  w.attempt,
+        Enqueued: w.enqueued,
+        Queued:   w.queued,
+    }
+    if pt, ok := w.t.(queues.PrioritizedTask); ok {
+        info.Key = pt.Key()
+        info.Prioritized = true
+    }
+    if w.attempt > 0 {
+        info.Started = w.started
+    }
+    return info
+}
+
+//  The tasks waiting in the queue, in the order they will be dequeued.
+//  Tasks waiting to be retried are not in the queue until their backoff
+//  delay has passed.
+func (gq *Dispatch) Queued() []TaskInfo {
+    gq.qLock.Lock()
+    defer gq.qLock.Unlock()
+    var tasks = make([]TaskInfo, 0, gq.queue.Len())
+    gq.queue.Do(func(task queues.RegisteredTask) bool {
+        tasks = append(tasks, taskInfo(task.(*dispatchTaskWrapper)))
+        return true
+    })
+    return tasks
+}
+
+//  The tasks currently running, in the order they started.
+func (gq *Dispatch) Running() []TaskInfo {
+    gq.pLock.Lock()
+    var tasks = make([]TaskInfo, 0, len(gq.running))
+    for _, w := range gq.running {
+        tasks = append(tasks, taskInfo(w))
+    }
+    gq.pLock.Unlock()
+    sort.Slice(tasks, func(i, j int) bool {
+        if !tasks[i].Started.Equal(tasks[j].Started) {
+            return tasks[i].Started.Before(tasks[j].Started)
+        }
+        return tasks[i].Id < tasks[j].Id
+    })
+    return tasks
+}
@@ -0,0 +1,112 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    stats.go
+ *  Description: Runtime statistics for a Dispatch.
+ */
+
+package dispatch
+
+import (
+    "errors"
+    "time"
+)
+
+//  A snapshot of the state and history of a Dispatch. Counts of started,
+//  completed and failed tasks count every attempt of a retried task.
+type Stats struct {
+    Queued  int // Tasks waiting in the queue.
+    Running int // Slots held by running tasks (see WeightedTask).
+    Stuck   int // Tasks past their deadline whose functions haven't returned.
+    MaxGo   int // The current limit on running tasks, possibly set by a Limiter.
+
+    Rate  float64 // The limit on task starts per second, zero if there is none.
+    Burst int     // Task starts allowed at once by the rate limit.
+
+    Enqueued  int64 // Tasks accepted by an Enqueue method.
+    Started   int64 // Task attempts started.
+    Completed int64 // Task attempts which returned without error.
+    Failed    int64 // Task attempts which returned an error, panicked or timed out.
+    Panicked  int64 // Task attempts which panicked (also counted as Failed).
+    TimedOut  int64 // Task attempts which ran past their deadline (also Failed).
+
+    // Time the Dispatch spent unable to start a task because MaxGo tasks
+    // were running, time it spent waiting on an empty queue, and time it
+    // spent waiting for the rate limit.
+    BlockedTime   time.Duration
+    IdleTime      time.Duration
+    ThrottledTime time.Duration
+}
+
+//  Returns a consistent snapshot of the Dispatch's statistics.
+func (gq *Dispatch) Stats() Stats {
+    var now = time.Now()
+    gq.qLock.Lock()
+    defer gq.qLock.Unlock()
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    var s = Stats{
+        Queued:        gq.queue.Len(),
+        Running:       gq.processing,
+        Stuck:         gq.stuck,
+        MaxGo:         gq.maxgo,
+        Enqueued:      gq.enqueued,
+        Started:       gq.begun,
+        Completed:     gq.completed,
+        Failed:        gq.failed,
+        Panicked:      gq.panicked,
+        TimedOut:      gq.timedOut,
+        BlockedTime:   gq.blocked.elapsed(now),
+        IdleTime:      gq.idle.elapsed(now),
+        ThrottledTime: gq.throttled.elapsed(now),
+    }
+    if gq.bucket.rate > 0 {
+        s.Rate, s.Burst = gq.bucket.rate, gq.bucket.burst
+    }
+    return s
+}
+
+//  Count the end of a task attempt which finished with err.
+func (gq *Dispatch) count(err error) {
+    var pe *PanicError
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    switch {
+    case err == nil:
+        gq.completed++
+        return
+    case err == ErrTimeout:
+        gq.timedOut++
+    case errors.As(err, &pe):
+        gq.panicked++
+    }
+    gq.failed++
+}
+
+//  Accumulates the time spent in some waiting state.
+type waitTimer struct {
+    total time.Duration
+    since time.Time // Zero when not waiting.
+}
+
+//  Begin waiting at time now.
+func (wt *waitTimer) start(now time.Time) {
+    wt.since = now
+}
+
+//  Stop waiting at time now. Does nothing if the timer wasn't started.
+func (wt *waitTimer) stop(now time.Time) {
+    if !wt.since.IsZero() {
+        wt.total += now.Sub(wt.since)
+        wt.since = time.Time{}
+    }
+}
+
+//  The total time spent waiting, including any wait in progress.
+func (wt *waitTimer) elapsed(now time.Time) time.Duration {
+    if wt.since.IsZero() {
+        return wt.total
+    }
+    return wt.total + now.Sub(wt.since)
+}
@@ -0,0 +1,175 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    timeline.go
+ *  Description: Recording task schedules as Chrome trace-event timelines.
+ */
+
+package dispatch
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "sync"
+    "time"
+)
+
+//  A Timeline records when the tasks of one or more Dispatches were
+//  enqueued, started and finished, and which slot each ran in. It writes
+//  the schedule in the Chrome trace-event format, which can be opened with
+//  chrome://tracing or https://ui.perfetto.dev. Each Dispatch is shown as
+//  a process with a thread per slot, plus a thread for time spent queued.
+//      tl := dispatch.NewTimeline()
+//      tl.Attach(gq)
+//      ...
+//      tl.WriteFile("schedule.json")
+//
+//  A Timeline keeps every event in memory until it is Reset.
+type Timeline struct {
+    lock   *sync.Mutex
+    origin time.Time
+    names  []string // Dispatch names, by process id - 1.
+    events []traceEvent
+    slots  map[[2]int]bool // Slots (pid, tid) seen so far.
+}
+
+//  One event in the trace-event format.
+type traceEvent struct {
+    Name string                 `json:"name"`
+    Cat  string                 `json:"cat,omitempty"`
+    Ph   string                 `json:"ph"`
+    Ts   float64                `json:"ts"`
+    Dur  float64                `json:"dur,omitempty"`
+    Pid  int                    `json:"pid"`
+    Tid  int                    `json:"tid"`
+    Id   string                 `json:"id,omitempty"`
+    Args map[string]interface{} `json:"args,omitempty"`
+}
+
+//  Create an empty Timeline. Timestamps are relative to its creation.
+func NewTimeline() *Timeline {
+    var tl = new(Timeline)
+    tl.lock = new(sync.Mutex)
+    tl.origin = time.Now()
+    tl.slots = make(map[[2]int]bool)
+    return tl
+}
+
+//  Start recording the tasks of gq.
+func (tl *Timeline) Attach(gq *Dispatch) {
+    tl.lock.Lock()
+    tl.names = append(tl.names, gq.Name)
+    var pid = len(tl.names)
+    tl.lock.Unlock()
+    gq.AddHooks(Hooks{
+        OnFinish: func(e TaskEvent) { tl.record(pid, e) },
+        OnDrop:   func(e TaskEvent) { tl.record(pid, e) },
+    })
+}
+
+//  Microseconds since the origin of the timeline.
+func (tl *Timeline) micros(t time.Time) float64 {
+    return float64(t.Sub(tl.origin)) / float64(time.Microsecond)
+}
+
+//  Record the queue wait, and any execution, of a finished or dropped task.
+func (tl *Timeline) record(pid int, e TaskEvent) {
+    var (
+        id    = e.Task.Id()
+        typ   = e.Task.Task().Type()
+        span  = fmt.Sprintf("%d.%d", id, e.Attempt)
+        until = e.Started
+        args  = map[string]interface{}{"id": id, "attempt": e.Attempt}
+    )
+    if e.Kind == EventDrop {
+        until = e.Finished
+        span = fmt.Sprintf("%d.%d", id, e.Attempt+1)
+        args["attempt"] = e.Attempt + 1
+        args["dropped"] = e.Err.Error()
+    }
+    tl.lock.Lock()
+    defer tl.lock.Unlock()
+    tl.events = append(tl.events,
+        traceEvent{Name: typ, Cat: "queue", Ph: "b", Ts: tl.micros(e.Queued), Pid: pid, Id: span, Args: args},
+        traceEvent{Name: typ, Cat: "queue", Ph: "e", Ts: tl.micros(until), Pid: pid, Id: span})
+    if e.Kind == EventDrop {
+        return
+    }
+    var runArgs = map[string]interface{}{
+        "id":       id,
+        "attempt":  e.Attempt,
+        "enqueued": tl.micros(e.Enqueued),
+    }
+    if e.Err != nil {
+        runArgs["error"] = e.Err.Error()
+    }
+    var tid = e.Slot + 1
+    tl.slots[[2]int{pid, tid}] = true
+    tl.events = append(tl.events, traceEvent{
+        Name: typ,
+        Cat:  "run",
+        Ph:   "X",
+        Ts:   tl.micros(e.Started),
+        Dur:  tl.micros(e.Finished) - tl.micros(e.Started),
+        Pid:  pid,
+        Tid:  tid,
+        Args: runArgs,
+    })
+}
+
+//  Discard all recorded events. Attached Dispatches remain attached.
+func (tl *Timeline) Reset() {
+    tl.lock.Lock()
+    defer tl.lock.Unlock()
+    tl.events = nil
+    tl.slots = make(map[[2]int]bool)
+}
+
+//  Write the recorded events to w as a trace-event JSON object.
+func (tl *Timeline) WriteTo(w io.Writer) (int64, error) {
+    tl.lock.Lock()
+    var events = make([]traceEvent, 0, len(tl.events)+2*len(tl.names)+len(tl.slots))
+    for i, name := range tl.names {
+        if name == "" {
+            name = fmt.Sprintf("dispatch %d", i+1)
+        }
+        events = append(events,
+            traceEvent{Name: "process_name", Ph: "M", Pid: i + 1,
+                Args: map[string]interface{}{"name": name}},
+            traceEvent{Name: "thread_name", Ph: "M", Pid: i + 1, Tid: 0,
+                Args: map[string]interface{}{"name": "queue"}})
+    }
+    for s := range tl.slots {
+        events = append(events, traceEvent{Name: "thread_name", Ph: "M", Pid: s[0], Tid: s[1],
+            Args: map[string]interface{}{"name": fmt.Sprintf("slot %d", s[1]-1)}})
+    }
+    events = append(events, tl.events...)
+    tl.lock.Unlock()
+
+    var data, err = json.Marshal(map[string]interface{}{
+        "traceEvents":     events,
+        "displayTimeUnit": "ms",
+    })
+    if err != nil {
+        return 0, err
+    }
+    var n int
+    n, err = w.Write(data)
+    return int64(n), err
+}
+
+//  Write the recorded events to the file at path.
+func (tl *Timeline) WriteFile(path string) error {
+    var f, err = os.Create(path)
+    if err != nil {
+        return err
+    }
+    if _, err = tl.WriteTo(f); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
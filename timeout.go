@@ -0,0 +1,127 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    timeout.go
+ *  Description: Execution timeouts and deadlines for dispatched tasks.
+ */
+
+package dispatch
+
+import (
+    "context"
+    "fmt"
+    "time"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  The error of a Future whose task ran past its deadline. It satisfies
+//  errors.Is(err, context.DeadlineExceeded).
+var ErrTimeout = fmt.Errorf("dispatch: task timed out: %w", context.DeadlineExceeded)
+
+//  A TimeoutTask is a queues.Task with a limit on its running time,
+//  measured from when it is started. A non-positive timeout is no limit.
+type TimeoutTask interface {
+    queues.Task
+    Timeout() time.Duration
+}
+
+//  A DeadlineTask is a queues.Task which must finish by an absolute time.
+//  A zero time is no deadline.
+type DeadlineTask interface {
+    queues.Task
+    Deadline() time.Time
+}
+
+//  One execution of a task's function. When a task runs past its deadline
+//  its context is cancelled and its slot is given back, but its goroutine
+//  can't be stopped. Whichever of the deadline and the function's return
+//  comes first ends the execution.
+type execution struct {
+    ctx    context.Context
+    cancel context.CancelFunc
+    timer  *time.Timer
+    ended  bool // Protected by the Dispatch's pLock.
+}
+
+//  Return the deadline for a task started at time start, considering the
+//  TimeoutTask and DeadlineTask interfaces. The boolean is false if the
+//  task has no deadline of its own.
+func taskDeadline(t queues.Task, start time.Time) (time.Time, bool) {
+    var deadline time.Time
+    if tt, ok := t.(TimeoutTask); ok && tt.Timeout() > 0 {
+        deadline = start.Add(tt.Timeout())
+    }
+    if dt, ok := t.(DeadlineTask); ok {
+        var d = dt.Deadline()
+        if !d.IsZero() && (deadline.IsZero() || d.Before(deadline)) {
+            deadline = d
+        }
+    }
+    return deadline, !deadline.IsZero()
+}
+
+//  Begin an execution of the task w. The execution's context is derived
+//  from the one w was enqueued with, so a deadline on that context limits
+//  the task as well.
+func (gq *Dispatch) execute(w *dispatchTaskWrapper) *execution {
+    var x = new(execution)
+    var ctx = context.WithValue(w.ctx, attemptKey{}, w.attempt)
+    if deadline, ok := taskDeadline(w.t, time.Now()); ok {
+        x.ctx, x.cancel = context.WithDeadline(ctx, deadline)
+    } else {
+        x.ctx, x.cancel = context.WithCancel(ctx)
+    }
+    if deadline, ok := x.ctx.Deadline(); ok {
+        x.timer = time.AfterFunc(time.Until(deadline), func() {
+            gq.timeout(w, x)
+        })
+    }
+    return x
+}
+
+//  Release the resources of an execution once its function returns.
+func (x *execution) stop() {
+    if x.timer != nil {
+        x.timer.Stop()
+    }
+    x.cancel()
+}
+
+//  Called when the execution x of task w reaches its deadline.
+func (gq *Dispatch) timeout(w *dispatchTaskWrapper, x *execution) {
+    if !gq.end(x, true) {
+        return
+    }
+    x.cancel()
+    gq.release(w)
+    gq.complete(w, nil, ErrTimeout)
+}
+
+//  End the execution x, returning false if it had already ended. An
+//  execution ended by its deadline is counted as stuck until its function
+//  returns.
+func (gq *Dispatch) end(x *execution, timedOut bool) bool {
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    if x.ended {
+        if !timedOut {
+            gq.stuck--
+        }
+        return false
+    }
+    x.ended = true
+    if timedOut {
+        gq.stuck++
+    }
+    return true
+}
+
+//  The number of tasks which were abandoned at their deadline but whose
+//  functions have not yet returned. These tasks no longer count against
+//  the concurrency limit.
+func (gq *Dispatch) Stuck() int {
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    return gq.stuck
+}
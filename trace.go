@@ -0,0 +1,38 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    trace.go
+ *  Description: Attributing tasks in execution traces and profiles.
+ */
+
+package dispatch
+
+import (
+    "context"
+    "runtime/pprof"
+    "runtime/trace"
+    "strconv"
+)
+
+//  Run an execution of the task w with pprof labels "dispatch", "task_type"
+//  and "task_id", inside a runtime/trace task and region named after the
+//  task's Type(). Goroutines started by the task inherit the labels, and
+//  the labelled context is what the task's function receives.
+func (gq *Dispatch) traced(w *dispatchTaskWrapper, ctx context.Context, run func(context.Context, int64) (interface{}, error)) (value interface{}, err error) {
+    var typ = w.t.Type()
+    var labels = pprof.Labels(
+        "dispatch", gq.Name,
+        "task_type", typ,
+        "task_id", strconv.FormatInt(w.id, 10))
+    pprof.Do(ctx, labels, func(ctx context.Context) {
+        var tctx, task = trace.NewTask(ctx, typ)
+        defer task.End()
+        // w.attempt may change if this attempt times out and is retried.
+        trace.Logf(tctx, "dispatch", "task %d attempt %d", w.id, Attempt(ctx))
+        trace.WithRegion(tctx, typ, func() {
+            value, err = gq.call(w, tctx, run)
+        })
+    })
+    return value, err
+}
@@ -0,0 +1,123 @@
+// Copyright 2026, The dispatch Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+/*
+ *  Filename:    weight.go
+ *  Description: Tasks which hold more than one concurrency slot.
+ */
+
+package dispatch
+
+import (
+    "time"
+    "github.com/bmatsuo/dispatch/queues"
+)
+
+//  A WeightedTask holds Weight() of the Dispatch's MaxGo slots while it
+//  runs, instead of one. It starts only when that many slots are free. A
+//  weight above the limit is treated as the whole limit, so that the task
+//  can start once nothing else is running, and a weight below one is
+//  treated as one.
+type WeightedTask interface {
+    queues.Task
+    Weight() int
+}
+
+//  A WeightPolicy decides what happens to the tasks behind a WeightedTask
+//  which is at the head of the queue but too heavy to start yet.
+type WeightPolicy int
+
+const (
+    // Start nothing until the head of the queue can start, so tasks
+    // always start in queue order. This is the default.
+    WeightWait WeightPolicy = iota
+    // Start the first queued task which fits in the free slots. Lighter
+    // tasks may then keep a heavy task waiting indefinitely.
+    WeightBypass
+)
+
+//  The slots task t holds while running, given a limit of maxgo.
+func taskWeight(t queues.Task, maxgo int) int {
+    var n = 1
+    if wt, ok := t.(WeightedTask); ok {
+        n = wt.Weight()
+    }
+    if n > maxgo {
+        n = maxgo
+    }
+    if n < 1 {
+        n = 1
+    }
+    return n
+}
+
+//  Take the next task which fits in the free slots out of the queue,
+//  counting the one slot gq.next() holds as free. If no task fits,
+//  nil is returned with the fewest slots a queued task needs. The caller
+//  must hold gq.qLock, and the queue must not be empty.
+func (gq *Dispatch) pick() (*dispatchTaskWrapper, int) {
+    gq.pLock.Lock()
+    var maxgo, free = gq.maxgo, gq.maxgo - gq.processing + 1
+    gq.pLock.Unlock()
+
+    var (
+        picked *dispatchTaskWrapper
+        head   = true
+        need   = 0
+    )
+    gq.queue.Do(func(task queues.RegisteredTask) bool {
+        var w = task.(*dispatchTaskWrapper)
+        var weight = taskWeight(w.t, maxgo)
+        if weight <= free {
+            picked = w
+            picked.weight = weight
+            return false
+        }
+        if need == 0 || weight < need {
+            need = weight
+        }
+        head = false
+        return gq.WeightPolicy == WeightBypass
+    })
+    switch {
+    case picked == nil:
+        return nil, need
+    case head:
+        gq.queue.Dequeue()
+    default:
+        gq.queue.Remove(picked.id)
+    }
+    return picked, 0
+}
+
+//  Give back the slot gq.next() holds and prepare to wait until need slots
+//  may be free. Returns false if they already are, or kill is closed, in
+//  which case there's no need to wait on gq.nextWait. The caller must hold
+//  gq.qLock, so that gq.push() can't enqueue a task which fits before
+//  gq.heavy is set.
+func (gq *Dispatch) waitHeavy(need int, kill chan bool) bool {
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    gq.processing--
+    if gq.maxgo-gq.processing >= need || killed(kill) {
+        return false
+    }
+    gq.waitingToRun = true
+    gq.heavy = true
+    gq.blocked.start(time.Now())
+    gq.nextWait.Add(1)
+    return true
+}
+
+//  Wake gq.next() if it waits for room for a WeightedTask, since a newly
+//  queued task might fit, or the task it waited for may have been
+//  removed. The caller must hold gq.qLock.
+func (gq *Dispatch) wakeHeavy() {
+    gq.pLock.Lock()
+    defer gq.pLock.Unlock()
+    if gq.heavy && gq.waitingToRun {
+        gq.heavy = false
+        gq.waitingToRun = false
+        gq.nextWait.Done()
+    }
+}